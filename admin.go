@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	models "github.com/rohith2506/facedetect/models"
+)
+
+var (
+	cacheSegmentRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	// purge patterns are globs over the detection keys, below the namespace
+	cachePatternRe = regexp.MustCompile(`^[A-Za-z0-9._:*?\[\]-]{1,256}$`)
+)
+
+// CachedEntry is a cached detection as shown by the admin api
+type CachedEntry struct {
+	Key    string       `json:"key"`
+	Output *RedisOutput `json:"output,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// requireAdmin rejects the requests which do not carry the admin token. The admin
+// api is only served when ADMIN_TOKEN is set, callers send it as a bearer token.
+func (app *App) requireAdmin(c *gin.Context) {
+	if app.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"access denied": "the admin api is disabled, set ADMIN_TOKEN"})
+		return
+	}
+	given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(app.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"access denied": "invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
+// detectionPattern returns the glob matching the detection keys for a pattern relative to the namespace
+func (app *App) detectionPattern(pattern string) string {
+	return strings.Join([]string{app.Namespace, detectionSpace, pattern}, ":")
+}
+
+// invalidateModel removes every detection cached for a version of a model, along with
+// its perceptual hash index whose entries would point at the removed detections
+func (app *App) invalidateModel(model string, version string) (int, error) {
+	removed, err := app.Cache.DeleteMatching(app.modelDetectionPattern(model, version))
+	if err != nil {
+		return removed, err
+	}
+	if model == models.MTCNNName && version == models.MTCNNVersion {
+		return removed, app.Index.Clear()
+	}
+	// the index of another version may still be in a shared redis
+	_, err = app.Cache.DeleteMatching(app.modelSimilarityPattern(model, version))
+	return removed, err
+}
+
+// CacheStatsHandler endpoint reports the hits, misses and size of the detection cache
+func (app *App) CacheStatsHandler(c *gin.Context) {
+	keys, err := app.Cache.Keys(app.detectionPattern("*"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"cache inspection failed": err.Error()})
+		return
+	}
+	// the locks live next to the detections
+	entries := 0
+	for _, key := range keys {
+		if imageHashRe.MatchString(key[strings.LastIndex(key, ":")+1:]) {
+			entries++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mode":    app.CacheMode,
+		"entries": entries,
+		"stats":   app.stats.Snapshot(),
+	})
+}
+
+// CacheEntryHandler endpoint shows every detection cached for the source image with the given hash
+func (app *App) CacheEntryHandler(c *gin.Context) {
+	imageHash := c.Param("hash")
+	if !imageHashRe.MatchString(imageHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid image hash": imageHash})
+		return
+	}
+
+	keys, err := app.Cache.Keys(app.imageDetectionPattern(imageHash))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"cache inspection failed": err.Error()})
+		return
+	}
+	if len(keys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"image not cached": imageHash})
+		return
+	}
+
+	entries := make([]CachedEntry, 0, len(keys))
+	for _, key := range keys {
+		entry := CachedEntry{Key: key}
+		value, err := app.Cache.Get(key)
+		if err == nil {
+			err = json.Unmarshal(value, &entry.Output)
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		entries = append(entries, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// CachePurgeHandler endpoint removes the detections matching the "pattern" query, a glob
+// relative to the namespace such as "mtcnn:1:*" or "*:0123456789abcdef0123456789abcdef"
+func (app *App) CachePurgeHandler(c *gin.Context) {
+	pattern := c.Query("pattern")
+	if !cachePatternRe.MatchString(pattern) {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid pattern": pattern})
+		return
+	}
+	removed, err := app.Cache.DeleteMatching(app.detectionPattern(pattern))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"cache purge failed": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
+
+// ModelCacheDeleteHandler endpoint removes the detections cached for a version of a model
+func (app *App) ModelCacheDeleteHandler(c *gin.Context) {
+	model, version := c.Param("model"), c.Param("version")
+	if !cacheSegmentRe.MatchString(model) || !cacheSegmentRe.MatchString(version) {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid model": model + ":" + version})
+		return
+	}
+	removed, err := app.invalidateModel(model, version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"cache invalidation failed": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
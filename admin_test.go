@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	models "github.com/rohith2506/facedetect/models"
+)
+
+func performAdminRequest(r http.Handler, method, path string, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCacheAdmin(t *testing.T) {
+	app := newTestApp(t)
+	app.AdminToken = "secret"
+
+	options := renderOptions{Style: models.StyleAnnotated, Extension: ".png"}
+	cached, _ := json.Marshal(RedisOutput{ImageKey: testImageHash + ".png", Width: 10, Height: 10})
+	if err := app.Cache.Set(app.detectionKey(testImageHash, options), cached, 0); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	router := SetupRouter(app)
+
+	w := performAdminRequest(router, "GET", "/v1/admin/cache/stats", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performAdminRequest(router, "GET", "/v1/admin/cache/images/"+testImageHash, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var inspected struct{ Entries []CachedEntry }
+	json.Unmarshal(w.Body.Bytes(), &inspected)
+	if len(inspected.Entries) != 1 || inspected.Entries[0].Output == nil || inspected.Entries[0].Output.Width != 10 {
+		t.Fatalf("unexpected entries: %s", w.Body.String())
+	}
+
+	w = performAdminRequest(router, "DELETE", "/v1/admin/cache?pattern=*:"+testImageHash, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"removed":1}`, w.Body.String())
+
+	w = performAdminRequest(router, "GET", "/v1/admin/cache/images/"+testImageHash, "secret")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performAdminRequest(router, "DELETE", "/v1/admin/cache?pattern=", "secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
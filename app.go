@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	cache "github.com/rohith2506/facedetect/cache"
+	config "github.com/rohith2506/facedetect/config"
+	events "github.com/rohith2506/facedetect/events"
+	fetch "github.com/rohith2506/facedetect/fetch"
+	jobs "github.com/rohith2506/facedetect/jobs"
+	models "github.com/rohith2506/facedetect/models"
+	redis "github.com/rohith2506/facedetect/redis"
+	storage "github.com/rohith2506/facedetect/storage"
+	webhooks "github.com/rohith2506/facedetect/webhooks"
+)
+
+// App holds the dependencies of the handlers. NewApp wires the real services,
+// the tests build it with fakes.
+type App struct {
+	Config   *config.Config
+	Detector models.Detector
+	Store    storage.ImageStore
+	Cache    cache.Cache
+	// Index finds the detections of near duplicate images
+	Index cache.SimilarityIndex
+	// Locker coordinates the detections with the other instances, nil when the cache is not shared
+	Locker    cache.Locker
+	CacheMode string
+	Fetcher   *fetch.Fetcher
+	Jobs      *jobs.Pool
+	Notifier  *webhooks.Notifier
+	Progress  *events.Broker
+	// Retention of the rendered images and of the cached detections, zero keeps them forever
+	Retention time.Duration
+	// Namespace prefixes every cache key
+	Namespace string
+	// CacheTTL is the lifetime of the cached detections, zero keeps them until evicted
+	CacheTTL time.Duration
+	// URLFreshness is how long a fetched url is trusted without asking the upstream server again
+	URLFreshness time.Duration
+	// HashDistance is the largest distance between the perceptual hashes of near duplicates, zero disables the lookup
+	HashDistance int
+	// AdminToken guards the admin api, which is disabled when it is empty
+	AdminToken string
+
+	stats      cache.Stats
+	detections cache.Group
+}
+
+// NewApp connects to the services described by settings and starts the job workers.
+// Redis backed caches and job state fall back to memory when redis cannot be reached.
+func NewApp(settings *config.Config) (*App, error) {
+	app := &App{
+		Config:       settings,
+		Detector:     models.NewMTCNN(settings.DetectorAddr(), settings.MTCNN.Timeout),
+		Progress:     events.NewBroker(eventRetention),
+		Namespace:    settings.Cache.Namespace,
+		CacheTTL:     settings.Cache.TTL,
+		URLFreshness: settings.Cache.URLFreshness,
+		HashDistance: settings.Cache.HashDistance,
+		AdminToken:   settings.AdminToken,
+		Retention:    settings.Store.Retention,
+	}
+
+	var err error
+	if app.Store, err = newImageStore(settings); err != nil {
+		return nil, err
+	}
+	fetchSettings := settings.FetchConfig()
+	fetchSettings.MaxBytes = maxImageSize
+	app.Fetcher = fetch.NewFetcher(fetchSettings)
+
+	conn := redis.NewConnection(settings.RedisConfig())
+	redisErr := conn.Ping()
+	app.setupCache(conn, redisErr)
+	if redisErr != nil {
+		log.Printf("Redis unavailable, keeping jobs in memory: %v", redisErr)
+		conn = nil
+	}
+	app.setupJobs(conn, fetchSettings)
+	return app, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	cache "github.com/rohith2506/facedetect/cache"
+	config "github.com/rohith2506/facedetect/config"
+	events "github.com/rohith2506/facedetect/events"
+	fetch "github.com/rohith2506/facedetect/fetch"
+	models "github.com/rohith2506/facedetect/models"
+	mtcnntest "github.com/rohith2506/facedetect/models/mtcnntest"
+	redistest "github.com/rohith2506/facedetect/redis/redistest"
+	storage "github.com/rohith2506/facedetect/storage"
+)
+
+// newDetector starts a stand-in for the python wrapper finding the face of test_images/elon.jpg on every image
+func newDetector(t *testing.T) *mtcnntest.Server {
+	server, err := mtcnntest.NewServer(mtcnntest.Elon)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// newTestApp returns an application keeping everything in memory and in a temporary local store.
+// Its detector is a stand-in and its fetcher reaches the local test servers.
+func newTestApp(t *testing.T) *App {
+	dir, err := ioutil.TempDir("", "facedetect-app")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store, err := storage.NewLocalStore(dir, localImagesPath, []byte("secret"), time.Hour)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	settings := config.Default()
+	app := &App{
+		Config:       settings,
+		Detector:     models.NewMTCNN(newDetector(t).Addr(), time.Second),
+		Store:        store,
+		Cache:        cache.NewLRU(settings.Cache.LRUEntries, settings.Cache.LRUBytes, 0),
+		CacheMode:    config.CacheMemory,
+		Fetcher:      fetch.NewFetcher(fetch.Config{MaxBytes: maxImageSize, Allow: fetch.MustParseCIDRs("127.0.0.0/8")}),
+		Progress:     events.NewBroker(eventRetention),
+		Namespace:    settings.Cache.Namespace,
+		CacheTTL:     settings.Cache.TTL,
+		URLFreshness: settings.Cache.URLFreshness,
+		HashDistance: settings.Cache.HashDistance,
+	}
+	app.Index = cache.NewMemoryIndex(app.similarityPrefix())
+	return app
+}
+
+func TestNewApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "facedetect-app")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	redisServer, err := redistest.NewServer()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer redisServer.Close()
+
+	settings := config.Default()
+	settings.Store.Backend = config.StoreLocal
+	settings.Store.LocalDir = dir
+	settings.Redis.Addr = redisServer.Addr
+	settings.Cache.Mode = config.CacheMemory
+	first, err := NewApp(settings)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	second, err := NewApp(settings)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, ok := first.Store.(*storage.LocalStore); !ok || first.CacheMode != config.CacheMemory || first.Locker != nil {
+		t.Fatalf("unexpected application %+v", first)
+	}
+
+	// every application owns its cache
+	if err := first.Cache.Set("key", []byte("value"), 0); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, err := second.Cache.Get("key"); err != cache.ErrNotFound {
+		t.Fatalf("expected the caches to be separate, got %v", err)
+	}
+
+	// while the redis backed caches are shared
+	settings.Cache.Mode = config.CacheRedis
+	if first, err = NewApp(settings); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if second, err = NewApp(settings); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if first.CacheMode != config.CacheRedis || first.Locker == nil {
+		t.Fatalf("unexpected application %+v", first)
+	}
+	if err := first.Cache.Set("key", []byte("value"), 0); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if value, err := second.Cache.Get("key"); err != nil || string(value) != "value" {
+		t.Fatalf("expected the cache to be shared, got %q: %v", value, err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	models "github.com/rohith2506/facedetect/models"
+	utilities "github.com/rohith2506/facedetect/utilities"
+)
+
+// Archive limits. They keep a malicious archive (zip bomb) from exhausting
+// memory or disk while it is being unpacked. Like the frames of a sequence, the
+// images are detected while the request waits, within the server write timeout.
+const (
+	maxArchiveSize      = 64 << 20 // 64 MiB
+	maxArchiveEntries   = maxSequenceFrames
+	maxArchiveEntrySize = 8 << 20   // 8 MiB
+	maxArchiveTotalSize = 128 << 20 // 128 MiB
+	manifestName        = "manifest.json"
+)
+
+var (
+	errTooManyEntries = fmt.Errorf("archives are limited to %d images, split larger ones", maxArchiveEntries)
+	errEntryTooLarge  = errors.New("archive entry exceeds the maximum image size")
+	errArchiveTooBig  = errors.New("archive exceeds the maximum uncompressed size")
+)
+
+// ArchiveEntry is the result of a single image found inside an archive
+type ArchiveEntry struct {
+	Name      string             `json:"name"`
+	Landmarks []models.Detection `json:"landmarks"`
+	ImageURL  string             `json:"image_url,omitempty"`
+	Output    string             `json:"output,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// archiveImage is an image entry read from an archive
+type archiveImage struct {
+	name      string
+	extension string
+	data      []byte
+}
+
+// extractArchive reads the image entries of the archive into memory. Non
+// image entries are ignored. Every limit is enforced on the bytes actually
+// read, not on the sizes declared in the archive headers.
+func extractArchive(reader *zip.Reader) ([]archiveImage, error) {
+	var (
+		images    []archiveImage
+		totalSize int64
+	)
+
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		extension := strings.ToLower(filepath.Ext(file.Name))
+		if _, found := utilities.Find(availableExtensions, extension); !found {
+			continue
+		}
+		if len(images) >= maxArchiveEntries {
+			return nil, errTooManyEntries
+		}
+		if file.UncompressedSize64 > maxArchiveEntrySize {
+			return nil, errEntryTooLarge
+		}
+
+		data, err := extractArchiveEntry(file)
+		if err != nil {
+			return nil, err
+		}
+		totalSize += int64(len(data))
+		if totalSize > maxArchiveTotalSize {
+			return nil, errArchiveTooBig
+		}
+
+		images = append(images, archiveImage{
+			name:      file.Name,
+			extension: extension,
+			data:      data,
+		})
+	}
+	return images, nil
+}
+
+// extractArchiveEntry reads a single archive entry
+func extractArchiveEntry(file *zip.File) ([]byte, error) {
+	entry, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+
+	data, err := utilities.ReadAllLimited(entry, maxArchiveEntrySize)
+	if err == utilities.ErrTooLarge {
+		return nil, errEntryTooLarge
+	}
+	return data, err
+}
+
+// archiveOutputName builds a unique name for a rendered image in the output archive
+func archiveOutputName(index int, name string, style string) string {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	extension := filepath.Ext(base)
+	return fmt.Sprintf("%03d_%s_%s%s", index, strings.TrimSuffix(base, extension), style, extension)
+}
+
+// renderArchiveEntry draws the faces on the decoded image and adds it to the archive
+func renderArchiveEntry(archive *zip.Writer, name string, img image.Image, extension string, faces []models.Detection, style string) error {
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return models.RenderImage(img, faces, style, writer, extension)
+}
+
+// ZipUploadHandler endpoint runs the face detection on every image of an uploaded zip archive.
+// When the "output" form value is set to "annotated" or "anonymized", the response is a zip archive
+// holding the rendered images together with the manifest, otherwise the manifest is returned as json.
+func (app *App) ZipUploadHandler(c *gin.Context) {
+	start := time.Now()
+	style := c.PostForm("output")
+	if style != "" && style != models.StyleAnnotated && style != models.StyleAnonymized {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid output": "possible outputs are [annotated, anonymized]"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid input file": err.Error()})
+		return
+	}
+	if strings.ToLower(filepath.Ext(file.Filename)) != ".zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid archive extension": "only zip archives are supported"})
+		return
+	}
+	if file.Size > maxArchiveSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"archive too large": fmt.Sprintf("maximum archive size is %d bytes", maxArchiveSize)})
+		return
+	}
+
+	upload, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid input file": err.Error()})
+		return
+	}
+	defer upload.Close()
+
+	reader, err := zip.NewReader(upload, file.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid zip archive": err.Error()})
+		return
+	}
+
+	images, err := extractArchive(reader)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"archive extraction failed": err.Error()})
+		return
+	}
+
+	var (
+		entries []ArchiveEntry
+		outputs = new(bytes.Buffer)
+		archive *zip.Writer
+	)
+	if style != "" {
+		archive = zip.NewWriter(outputs)
+	}
+
+	for i, current := range images {
+		entry := ArchiveEntry{Name: current.name}
+		// the image is decoded once, for the detection and the rendering
+		img, err := decodeImage(current.data)
+		if err != nil {
+			entry.Error = err.Error()
+			entries = append(entries, entry)
+			continue
+		}
+		output, err := app.detectDecoded(current.data, img, current.extension, nil)
+		if err != nil {
+			entry.Error = err.Error()
+			entries = append(entries, entry)
+			continue
+		}
+		entry.Landmarks = output.Landmarks
+		// the stored rendering is annotated, it would expose the faces an anonymized archive blurs
+		if style != models.StyleAnonymized {
+			entry.ImageURL = output.ImageURL
+		}
+
+		if archive != nil {
+			entry.Output = archiveOutputName(i, current.name, style)
+			err := renderArchiveEntry(archive, entry.Output, img, current.extension, output.Landmarks, style)
+			if err != nil {
+				log.Printf("Error rendering archive entry %s: %v", current.name, err)
+				entry.Output = ""
+				entry.Error = err.Error()
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	elapsed := time.Since(start)
+	if archive == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"entries":   entries,
+			"time_took": elapsed.Milliseconds(),
+		})
+		return
+	}
+
+	manifest, err := archive.Create(manifestName)
+	if err == nil {
+		err = json.NewEncoder(manifest).Encode(gin.H{
+			"entries":   entries,
+			"time_took": elapsed.Milliseconds(),
+		})
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"archive creation failed": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\"facedetect.zip\"")
+	c.Data(http.StatusOK, "application/zip", outputs.Bytes())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	models "github.com/rohith2506/facedetect/models"
+)
+
+func createTestArchive(t *testing.T, files map[string][]byte) *zip.Reader {
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("error in creating archive entry: %v", err)
+		}
+		w.Write(content)
+	}
+	writer.Close()
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("error in reading archive: %v", err)
+	}
+	return reader
+}
+
+func TestExtractArchive(t *testing.T) {
+	reader := createTestArchive(t, map[string][]byte{
+		"faces/elon.jpg": []byte("jpeg"),
+		"me.PNG":         []byte("png"),
+		"notes.txt":      []byte("text"),
+	})
+	images, err := extractArchive(reader)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(images))
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	reader := createTestArchive(t, map[string][]byte{
+		"large.jpg": make([]byte, maxArchiveEntrySize+1),
+	})
+	if _, err := extractArchive(reader); err != errEntryTooLarge {
+		t.Fatalf("expected %v, got %v", errEntryTooLarge, err)
+	}
+
+	files := make(map[string][]byte)
+	for i := 0; i <= maxArchiveEntries; i++ {
+		files[archiveOutputName(i, "image.jpg", "test")] = []byte("jpeg")
+	}
+	if _, err := extractArchive(createTestArchive(t, files)); err != errTooManyEntries {
+		t.Fatalf("expected %v, got %v", errTooManyEntries, err)
+	}
+}
+
+func performZipRequest(r http.Handler, archive []byte, style string) *httptest.ResponseRecorder {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	w, _ := mw.CreateFormFile("file", "faces.zip")
+	w.Write(archive)
+	mw.WriteField("output", style)
+	mw.Close()
+	req, _ := http.NewRequest("POST", "/upload/zip", buf)
+	req.Header.Add("Content-Type", mw.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestZipUploadManifest(t *testing.T) {
+	image, err := ioutil.ReadFile("test_images/elon.jpg")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+	entry, _ := writer.Create("elon.jpg")
+	entry.Write(image)
+	writer.Close()
+	router := SetupRouter(newTestApp(t))
+
+	for _, test := range []struct {
+		style   string
+		withURL bool
+	}{
+		{style: models.StyleAnnotated, withURL: true},
+		// the stored image is annotated, an anonymized archive must not point at it
+		{style: models.StyleAnonymized, withURL: false},
+	} {
+		w := performZipRequest(router, archive.Bytes(), test.style)
+		assert.Equal(t, http.StatusOK, w.Code)
+		reader, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		var manifest struct{ Entries []ArchiveEntry }
+		for _, file := range reader.File {
+			if file.Name != manifestName {
+				continue
+			}
+			content, _ := file.Open()
+			json.NewDecoder(content).Decode(&manifest)
+			content.Close()
+		}
+		if len(manifest.Entries) != 1 || manifest.Entries[0].Output == "" {
+			t.Fatalf("unexpected %s manifest: %+v", test.style, manifest)
+		}
+		if (manifest.Entries[0].ImageURL != "") != test.withURL {
+			t.Fatalf("unexpected image url in the %s manifest: %q", test.style, manifest.Entries[0].ImageURL)
+		}
+	}
+}
+
+func TestZipUploadPixelLimit(t *testing.T) {
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+	entry, _ := writer.Create("huge.png")
+	entry.Write(encodePNGHeader(100000, 100000))
+	writer.Close()
+	router := SetupRouter(newTestApp(t))
+
+	w := performZipRequest(router, archive.Bytes(), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct{ Entries []ArchiveEntry }
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Entries) != 1 || !strings.Contains(response.Entries[0].Error, "pixels") {
+		t.Fatalf("expected the entry to be rejected for its dimensions, got %s", w.Body.String())
+	}
+}
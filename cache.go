@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	cache "github.com/rohith2506/facedetect/cache"
+	config "github.com/rohith2506/facedetect/config"
+	models "github.com/rohith2506/facedetect/models"
+	redis "github.com/rohith2506/facedetect/redis"
+)
+
+// Layout of the cache keys, every key starts with the namespace
+const (
+	detectionSpace  = "detection"
+	similaritySpace = "phash"
+	urlSpace        = "url"
+	// the image space references the url records and the jobs of every source image
+	imageSpace = "image"
+	urlRef     = "url"
+	jobRef     = "job"
+)
+
+// renderOptions changes the rendered image, so it is part of the cache key
+type renderOptions struct {
+	Style     string `json:"style"`
+	Extension string `json:"extension"`
+}
+
+// hash returns a short digest of the options
+func (options renderOptions) hash() string {
+	encoded, _ := json.Marshal(options)
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:8])
+}
+
+// detectionKey returns the cache key of the detection made on the source image
+// with the given hash, e.g. facedetect:detection:mtcnn:1:<options>:<hash>
+func (app *App) detectionKey(imageHash string, options renderOptions) string {
+	return strings.Join([]string{app.Namespace, detectionSpace, models.MTCNNName, models.MTCNNVersion, options.hash(), imageHash}, ":")
+}
+
+// imageDetectionPattern matches the cached detections of a source image, whatever the model and the options
+func (app *App) imageDetectionPattern(imageHash string) string {
+	return strings.Join([]string{app.Namespace, detectionSpace, "*", imageHash}, ":")
+}
+
+// imageRefKey returns the key noting that the url record or the job identified by id refers
+// to the source image with the given hash, e.g. facedetect:image:<hash>:job:<id>
+func (app *App) imageRefKey(imageHash string, kind string, id string) string {
+	return strings.Join([]string{app.Namespace, imageSpace, imageHash, kind, id}, ":")
+}
+
+// imageRefPattern matches the references to the source image with the given hash
+func (app *App) imageRefPattern(imageHash string) string {
+	return strings.Join([]string{app.Namespace, imageSpace, imageHash, "*"}, ":")
+}
+
+// modelDetectionPattern matches the cached detections made by a version of a model
+func (app *App) modelDetectionPattern(model string, version string) string {
+	return strings.Join([]string{app.Namespace, detectionSpace, model, version, "*"}, ":")
+}
+
+// similarityPrefix returns the prefix of the perceptual hash index of the current model,
+// e.g. facedetect:phash:mtcnn:1. It lies outside of the detections, which are matched by globs.
+func (app *App) similarityPrefix() string {
+	return strings.Join([]string{app.Namespace, similaritySpace, models.MTCNNName, models.MTCNNVersion}, ":")
+}
+
+// modelSimilarityPattern matches the perceptual hash index of a version of a model
+func (app *App) modelSimilarityPattern(model string, version string) string {
+	return strings.Join([]string{app.Namespace, similaritySpace, model, version, "*"}, ":")
+}
+
+// detectionTTL returns how long a detection is cached, never longer than the images are retained
+func (app *App) detectionTTL() time.Duration {
+	if app.Retention > 0 && (app.CacheTTL == 0 || app.Retention < app.CacheTTL) {
+		return app.Retention
+	}
+	return app.CacheTTL
+}
+
+// setupCache creates the detection cache selected by the cache mode. Redis backed
+// modes fall back to memory when redis could not be reached, as told by redisErr.
+func (app *App) setupCache(conn *redis.Connection, redisErr error) {
+	settings := app.Config.Cache
+	mode := settings.Mode
+	if mode != config.CacheMemory && redisErr != nil {
+		log.Printf("Redis unavailable, caching detections in memory: %v", redisErr)
+		mode = config.CacheMemory
+	}
+
+	app.CacheMode = mode
+	if mode == config.CacheRedis {
+		app.Cache = cache.NewRedis(conn)
+	} else {
+		lru := cache.NewLRU(settings.LRUEntries, settings.LRUBytes, settings.LRUTTL)
+		app.Cache = lru
+		if mode == config.CacheTiered {
+			app.Cache = cache.NewTiered(lru, cache.NewRedis(conn))
+		}
+	}
+	if mode == config.CacheMemory {
+		app.Index = cache.NewMemoryIndex(app.similarityPrefix())
+	} else {
+		app.Index = cache.NewRedisIndex(conn, app.similarityPrefix())
+		app.Locker = cache.NewRedisLocker(conn)
+	}
+}
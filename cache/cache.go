@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	redis "github.com/rohith2506/facedetect/redis"
+)
+
+// ErrNotFound is returned by Get when the key is not cached
+var ErrNotFound = errors.New("key not cached")
+
+// Cache keeps small values, such as detection results, for a limited time
+type Cache interface {
+	Get(key string) ([]byte, error)
+	// Set stores the value, a zero ttl keeps it until it is evicted or deleted
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys and returns how many of them were cached
+	Delete(keys ...string) (int, error)
+	// DeleteMatching removes the keys matching the glob pattern, e.g. "detection:*"
+	DeleteMatching(pattern string) (int, error)
+	// Keys returns the cached keys matching the glob pattern
+	Keys(pattern string) ([]string, error)
+}
+
+// Redis keeps the values in redis
+type Redis struct {
+	conn *redis.Connection
+}
+
+// NewRedis ...
+func NewRedis(conn *redis.Connection) *Redis {
+	return &Redis{conn: conn}
+}
+
+// Get ...
+func (cache *Redis) Get(key string) ([]byte, error) {
+	value, err := cache.conn.GetKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, ErrNotFound
+	}
+	return []byte(value), nil
+}
+
+// Set ...
+func (cache *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	return cache.conn.SetKeyWithExpiry(key, value, ttl)
+}
+
+// Delete ...
+func (cache *Redis) Delete(keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	removed, err := cache.conn.DeleteKeys(keys...)
+	return int(removed), err
+}
+
+// DeleteMatching ...
+func (cache *Redis) DeleteMatching(pattern string) (int, error) {
+	removed, err := cache.conn.DeleteMatching(pattern)
+	return int(removed), err
+}
+
+// Keys ...
+func (cache *Redis) Keys(pattern string) ([]string, error) {
+	return cache.conn.Keys(pattern)
+}
+
+// Tiered answers from a local cache first and falls back to a shared remote
+// cache, copying the values it finds there into the local one. The ttl of the
+// local cache bounds how long an instance may serve a value deleted elsewhere.
+type Tiered struct {
+	local  Cache
+	remote Cache
+}
+
+// NewTiered ...
+func NewTiered(local Cache, remote Cache) *Tiered {
+	return &Tiered{local: local, remote: remote}
+}
+
+// Get ...
+func (cache *Tiered) Get(key string) ([]byte, error) {
+	value, err := cache.local.Get(key)
+	if err != ErrNotFound {
+		return value, err
+	}
+	value, err = cache.remote.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.local.Set(key, value, 0); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set ...
+func (cache *Tiered) Set(key string, value []byte, ttl time.Duration) error {
+	if err := cache.remote.Set(key, value, ttl); err != nil {
+		return err
+	}
+	return cache.local.Set(key, value, ttl)
+}
+
+// Delete ...
+func (cache *Tiered) Delete(keys ...string) (int, error) {
+	localRemoved, err := cache.local.Delete(keys...)
+	if err != nil {
+		return 0, err
+	}
+	remoteRemoved, err := cache.remote.Delete(keys...)
+	if localRemoved > remoteRemoved {
+		return localRemoved, err
+	}
+	return remoteRemoved, err
+}
+
+// DeleteMatching ...
+func (cache *Tiered) DeleteMatching(pattern string) (int, error) {
+	localRemoved, err := cache.local.DeleteMatching(pattern)
+	if err != nil {
+		return 0, err
+	}
+	remoteRemoved, err := cache.remote.DeleteMatching(pattern)
+	if localRemoved > remoteRemoved {
+		return localRemoved, err
+	}
+	return remoteRemoved, err
+}
+
+// Keys returns the keys of both tiers
+func (cache *Tiered) Keys(pattern string) ([]string, error) {
+	keys, err := cache.remote.Keys(pattern)
+	if err != nil {
+		return nil, err
+	}
+	localKeys, err := cache.local.Keys(pattern)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for _, key := range localKeys {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
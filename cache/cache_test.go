@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	redis "github.com/rohith2506/facedetect/redis"
+	redistest "github.com/rohith2506/facedetect/redis/redistest"
+)
+
+func newTestRedis(t *testing.T) (*redis.Connection, *redistest.Server) {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.RegisterScript(redis.DeleteIfEqualsHash(), redistest.CompareAndDelete)
+	return redis.NewConnection(redis.Config{Addr: server.Addr}), server
+}
+
+func TestLRUEviction(t *testing.T) {
+	cache := NewLRU(2, 0, 0)
+	cache.Set("a", []byte("1"), 0)
+	cache.Set("b", []byte("2"), 0)
+	cache.Get("a")
+	cache.Set("c", []byte("3"), 0)
+
+	if _, err := cache.Get("b"); err != ErrNotFound {
+		t.Fatalf("expected the least recently used key to be evicted, got %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("expected %s to be cached: %v", key, err)
+		}
+	}
+}
+
+func TestLRUMaxBytes(t *testing.T) {
+	cache := NewLRU(0, 4, 0)
+	cache.Set("a", []byte("12"), 0)
+	cache.Set("b", []byte("34"), 0)
+	cache.Set("c", []byte("5"), 0)
+	if _, err := cache.Get("a"); err != ErrNotFound {
+		t.Fatalf("expected a to be evicted, got %v", err)
+	}
+	cache.Set("d", []byte("too large"), 0)
+	if _, err := cache.Get("d"); err != ErrNotFound || cache.Len() != 2 {
+		t.Fatalf("expected an oversized value to be skipped, got %v with %d entries", err, cache.Len())
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	now := time.Now()
+	cache := NewLRU(0, 0, time.Minute)
+	cache.now = func() time.Time { return now }
+	cache.Set("a", []byte("1"), time.Hour)
+	cache.Set("b", []byte("2"), time.Second)
+
+	now = now.Add(2 * time.Second)
+	if _, err := cache.Get("b"); err != ErrNotFound {
+		t.Fatalf("expected b to expire, got %v", err)
+	}
+	if _, err := cache.Get("a"); err != nil {
+		t.Fatalf("expected a to be cached: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := cache.Get("a"); err != ErrNotFound {
+		t.Fatalf("expected the cache ttl to bound a, got %v", err)
+	}
+}
+
+func TestTiered(t *testing.T) {
+	local := NewLRU(10, 0, 0)
+	remote := NewLRU(10, 0, 0)
+	cache := NewTiered(local, remote)
+
+	remote.Set("a", []byte("1"), 0)
+	if value, err := cache.Get("a"); err != nil || string(value) != "1" {
+		t.Fatalf("expected the remote value, got %q: %v", value, err)
+	}
+	if _, err := local.Get("a"); err != nil {
+		t.Fatalf("expected the value to be copied to the local cache: %v", err)
+	}
+
+	cache.Set("b", []byte("2"), 0)
+	if _, err := remote.Get("b"); err != nil {
+		t.Fatalf("expected the value to be written through: %v", err)
+	}
+	if removed, err := cache.Delete("a", "b", "c"); err != nil || removed != 2 {
+		t.Fatalf("expected 2 removed keys, got %d: %v", removed, err)
+	}
+	if _, err := cache.Get("a"); err != ErrNotFound {
+		t.Fatalf("expected a to be deleted from both tiers, got %v", err)
+	}
+}
+
+func TestLRUDeleteMatching(t *testing.T) {
+	cache := NewLRU(0, 0, 0)
+	for _, key := range []string{"detection:mtcnn:1:a", "detection:mtcnn:1:b", "detection:mtcnn:2:a"} {
+		cache.Set(key, []byte("{}"), 0)
+	}
+	if removed, err := cache.DeleteMatching("detection:mtcnn:1:*"); err != nil || removed != 2 {
+		t.Fatalf("expected 2 removed keys, got %d: %v", removed, err)
+	}
+	if _, err := cache.Get("detection:mtcnn:2:a"); err != nil {
+		t.Fatalf("expected the other version to be kept: %v", err)
+	}
+	if _, err := cache.DeleteMatching("["); err == nil {
+		t.Fatalf("expected an error for an invalid pattern")
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	index := NewMemoryIndex("phash")
+	index.Add("elon", 0xf0f0f0f0f0f0f0f0, 0)
+	index.Add("other", 0x0f0f0f0f0f0f0f0f, 0)
+
+	id, distance, err := index.Nearest(0xf0f0f0f0f0f0f0f7, 3)
+	if err != nil || id != "elon" || distance != 3 {
+		t.Fatalf("expected elon at distance 3, got %s at %d: %v", id, distance, err)
+	}
+	if _, _, err := index.Nearest(0xf0f0f0f0f0f0f0ff, 3); err != ErrNotFound {
+		t.Fatalf("expected no image within distance 3, got %v", err)
+	}
+	if err := index.Remove("elon"); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, _, err := index.Nearest(0xf0f0f0f0f0f0f0f0, 3); err != ErrNotFound {
+		t.Fatalf("expected the removed image to be forgotten, got %v", err)
+	}
+
+	now := time.Now()
+	index.now = func() time.Time { return now }
+	index.Add("expiring", 0x1234, time.Minute)
+	now = now.Add(2 * time.Minute)
+	if _, _, err := index.Nearest(0x1234, 0); err != ErrNotFound {
+		t.Fatalf("expected the entry to expire, got %v", err)
+	}
+}
+
+func TestIndexMaxDistance(t *testing.T) {
+	index := NewMemoryIndex("phash")
+	index.Add("spread", 0x0001000100010000, 0)
+
+	// MaxDistance bits spread over as many bands still leave one band intact
+	if id, distance, err := index.Nearest(0, MaxDistance); err != nil || id != "spread" || distance != MaxDistance {
+		t.Fatalf("expected spread at distance %d, got %s at %d: %v", MaxDistance, id, distance, err)
+	}
+	// one more bit may change every band, so the image is out of reach whatever the distance asked for
+	if _, _, err := index.Nearest(0x0000000000000001, MaxDistance+1); err != ErrNotFound {
+		t.Fatalf("expected the image to be out of reach of the bands, got %v", err)
+	}
+}
+
+func TestRedisIndex(t *testing.T) {
+	conn, _ := newTestRedis(t)
+	index := NewRedisIndex(conn, "phash")
+	if err := index.Add("elon", 0xf0f0f0f0f0f0f0f0, time.Minute); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if err := index.Add("other", 0xf0f0f0f0f0f0ffff, time.Minute); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	id, distance, err := index.Nearest(0xf0f0f0f0f0f0f0f1, 3)
+	if err != nil || id != "elon" || distance != 1 {
+		t.Fatalf("expected elon at distance 1, got %s at %d: %v", id, distance, err)
+	}
+	if err := index.Remove("elon"); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, _, err := index.Nearest(0xf0f0f0f0f0f0f0f1, 3); err != ErrNotFound {
+		t.Fatalf("expected the removed image to be forgotten, got %v", err)
+	}
+	if id, _, err := index.Nearest(0xf0f0f0f0f0f0fff0, 4); err != nil || id != "other" {
+		t.Fatalf("expected the other image to be kept, got %s: %v", id, err)
+	}
+	if err := index.Remove("unknown"); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if err := index.Clear(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, _, err := index.Nearest(0xf0f0f0f0f0f0fff0, 4); err != ErrNotFound {
+		t.Fatalf("expected the index to be cleared, got %v", err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var (
+		group   Group
+		calls   int32
+		started = make(chan struct{})
+		release = make(chan struct{})
+		results = make(chan bool, 3)
+	)
+	detect := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-release
+		return "faces", nil
+	}
+
+	go func() {
+		value, shared, err := group.Do("elon", detect)
+		results <- value == "faces" && !shared && err == nil
+	}()
+	<-started
+	for i := 0; i < 2; i++ {
+		go func() {
+			value, shared, err := group.Do("elon", detect)
+			results <- value == "faces" && shared && err == nil
+		}()
+	}
+	// give the waiters time to join the call in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		if !<-results {
+			t.Fatalf("expected every caller to get the shared result")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single call, got %d", calls)
+	}
+}
+
+func TestStats(t *testing.T) {
+	var stats Stats
+	stats.Hit()
+	stats.Hit()
+	stats.Hit()
+	stats.Miss()
+	stats.Set()
+	snapshot := stats.Snapshot()
+	if snapshot.Hits != 3 || snapshot.Misses != 1 || snapshot.Sets != 1 || snapshot.HitRatio != 0.75 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+}
+
+func TestRedisLocker(t *testing.T) {
+	conn, server := newTestRedis(t)
+	now := time.Now()
+	server.SetNow(func() time.Time { return now })
+	locker := NewRedisLocker(conn)
+
+	release, err := locker.TryLock("lock", time.Minute)
+	if err != nil || release == nil {
+		t.Fatalf("expected the lock, got %v", err)
+	}
+	if other, err := locker.TryLock("lock", time.Minute); err != nil || other != nil {
+		t.Fatalf("expected the lock to be held, got %v", err)
+	}
+	if held, err := locker.Held("lock"); err != nil || !held {
+		t.Fatalf("expected the lock to be held, got %v", err)
+	}
+	if err := release(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if held, _ := locker.Held("lock"); held {
+		t.Fatal("expected the released lock to be free")
+	}
+
+	// a holder which never comes back loses the lock once it expires
+	stale, _ := locker.TryLock("lock", time.Minute)
+	now = now.Add(2 * time.Minute)
+	if held, _ := locker.Held("lock"); held {
+		t.Fatal("expected the lock to expire")
+	}
+	next, err := locker.TryLock("lock", time.Minute)
+	if err != nil || next == nil {
+		t.Fatalf("expected the expired lock to be taken over, got %v", err)
+	}
+	// and cannot release the lock of the next holder
+	if err := stale(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if held, _ := locker.Held("lock"); !held {
+		t.Fatal("expected the stale release to leave the next holder alone")
+	}
+	if err := next(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if held, _ := locker.Held("lock"); held {
+		t.Fatal("expected the lock to be released")
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	redis "github.com/rohith2506/facedetect/redis"
+)
+
+const lockTokenBytes = 16
+
+// Group coalesces concurrent calls sharing a key: the first caller runs the
+// function, the others wait for it and get the same result.
+type Group struct {
+	mutex sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done  sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// Do runs fn unless a call with the same key is in flight, in which case it
+// waits for that call. shared tells whether the result came from another caller.
+func (group *Group) Do(key string, fn func() (interface{}, error)) (value interface{}, shared bool, err error) {
+	group.mutex.Lock()
+	if group.calls == nil {
+		group.calls = make(map[string]*call)
+	}
+	if current, found := group.calls[key]; found {
+		group.mutex.Unlock()
+		current.done.Wait()
+		return current.value, true, current.err
+	}
+	current := new(call)
+	current.done.Add(1)
+	group.calls[key] = current
+	group.mutex.Unlock()
+
+	defer func() {
+		group.mutex.Lock()
+		delete(group.calls, key)
+		group.mutex.Unlock()
+		current.done.Done()
+	}()
+	current.value, current.err = fn()
+	return current.value, false, current.err
+}
+
+// Locker hands out locks shared between the instances of the application
+type Locker interface {
+	// TryLock takes the lock without waiting. The returned release function is
+	// only set when the lock was acquired, the lock expires after ttl anyway.
+	TryLock(key string, ttl time.Duration) (release func() error, err error)
+	// Held tells whether someone holds the lock
+	Held(key string) (bool, error)
+}
+
+// RedisLocker keeps the locks in redis. Every lock holds a random token, so
+// that a holder whose lock expired cannot release the lock of the next one.
+type RedisLocker struct {
+	conn *redis.Connection
+}
+
+// NewRedisLocker ...
+func NewRedisLocker(conn *redis.Connection) *RedisLocker {
+	return &RedisLocker{conn: conn}
+}
+
+// TryLock ...
+func (locker *RedisLocker) TryLock(key string, ttl time.Duration) (func() error, error) {
+	token := make([]byte, lockTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	value := hex.EncodeToString(token)
+	acquired, err := locker.conn.SetKeyIfAbsent(key, value, ttl)
+	if err != nil || !acquired {
+		return nil, err
+	}
+	return func() error {
+		_, err := locker.conn.DeleteKeyIfEquals(key, value)
+		return err
+	}, nil
+}
+
+// Held ...
+func (locker *RedisLocker) Held(key string) (bool, error) {
+	value, err := locker.conn.GetKey(key)
+	return value != "", err
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"container/list"
+	"path"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process cache bounded by the number of entries, the total size
+// of the values and a ttl. The least recently used entries are evicted first.
+// A zero bound disables it.
+type LRU struct {
+	mutex      sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+	size       int64
+	order      *list.List
+	entries    map[string]*list.Element
+	now        func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU ...
+func NewLRU(maxEntries int, maxBytes int64, ttl time.Duration) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get ...
+func (cache *LRU) Get(key string) ([]byte, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, found := cache.entries[key]
+	if !found {
+		return nil, ErrNotFound
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expires.IsZero() && cache.now().After(entry.expires) {
+		cache.remove(element)
+		return nil, ErrNotFound
+	}
+	cache.order.MoveToFront(element)
+	return entry.value, nil
+}
+
+// Set stores the value for the shorter of ttl and the ttl of the cache
+func (cache *LRU) Set(key string, value []byte, ttl time.Duration) error {
+	if cache.ttl > 0 && (ttl <= 0 || cache.ttl < ttl) {
+		ttl = cache.ttl
+	}
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = cache.now().Add(ttl)
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, found := cache.entries[key]; found {
+		cache.remove(element)
+	}
+	// a value larger than the whole cache would only evict everything else
+	if cache.maxBytes > 0 && int64(len(value)) > cache.maxBytes {
+		return nil
+	}
+	cache.entries[key] = cache.order.PushFront(entry)
+	cache.size += int64(len(value))
+
+	for (cache.maxEntries > 0 && cache.order.Len() > cache.maxEntries) ||
+		(cache.maxBytes > 0 && cache.size > cache.maxBytes) {
+		cache.remove(cache.order.Back())
+	}
+	return nil
+}
+
+// Delete ...
+func (cache *LRU) Delete(keys ...string) (int, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	removed := 0
+	for _, key := range keys {
+		if element, found := cache.entries[key]; found {
+			cache.remove(element)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// DeleteMatching ...
+func (cache *LRU) DeleteMatching(pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	removed := 0
+	for key, element := range cache.entries {
+		if matched, _ := path.Match(pattern, key); matched {
+			cache.remove(element)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Keys ...
+func (cache *LRU) Keys(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := cache.now()
+	var keys []string
+	for key, element := range cache.entries {
+		entry := element.Value.(*lruEntry)
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			continue
+		}
+		if matched, _ := path.Match(pattern, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Len returns the number of cached entries, expired ones included
+func (cache *LRU) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.order.Len()
+}
+
+func (cache *LRU) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*lruEntry)
+	delete(cache.entries, entry.key)
+	cache.size -= int64(len(entry.value))
+}
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/rohith2506/facedetect/redis"
+	utilities "github.com/rohith2506/facedetect/utilities"
+)
+
+// The 64 bit perceptual hashes are split in bands of 16 bits. Two hashes
+// within a distance of 3 share at least one band, so looking up the images
+// sharing a band with the wanted hash finds every such near duplicate.
+const (
+	hashBands = 4
+	bandBits  = 64 / hashBands
+	// MaxDistance is the largest distance at which Nearest finds every near duplicate,
+	// further ones differ in every band and are only found by chance
+	MaxDistance = hashBands - 1
+)
+
+// SimilarityIndex finds the images whose perceptual hash is close to a given one
+type SimilarityIndex interface {
+	// Add indexes the image identified by id, a zero ttl keeps it forever
+	Add(id string, hash uint64, ttl time.Duration) error
+	// Nearest returns the closest image within maxDistance and its distance, or ErrNotFound
+	Nearest(hash uint64, maxDistance int) (string, int, error)
+	// Remove forgets the image identified by id
+	Remove(id string) error
+	// Clear forgets every image
+	Clear() error
+}
+
+// bandKeys returns the keys of the bands of the hash, below prefix
+func bandKeys(prefix string, hash uint64) []string {
+	keys := make([]string, hashBands)
+	for band := 0; band < hashBands; band++ {
+		value := (hash >> (band * bandBits)) & (1<<bandBits - 1)
+		keys[band] = fmt.Sprintf("%s:%d:%04x", prefix, band, value)
+	}
+	return keys
+}
+
+// member encodes the indexed image as stored in a band
+func member(id string, hash uint64) string {
+	return fmt.Sprintf("%016x:%s", hash, id)
+}
+
+// nearest returns the member closest to hash within maxDistance
+func nearest(members []string, hash uint64, maxDistance int) (string, int, error) {
+	bestID, bestDistance := "", maxDistance+1
+	for _, current := range members {
+		parts := strings.SplitN(current, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		candidate, err := strconv.ParseUint(parts[0], 16, 64)
+		if err != nil {
+			continue
+		}
+		distance := utilities.HammingDistance(hash, candidate)
+		if distance < bestDistance || (distance == bestDistance && parts[1] < bestID) {
+			bestID, bestDistance = parts[1], distance
+		}
+	}
+	if bestID == "" {
+		return "", 0, ErrNotFound
+	}
+	return bestID, bestDistance, nil
+}
+
+// RedisIndex keeps the bands as redis sets below a key prefix, along with
+// the hash of every image so that it can be removed from its bands
+type RedisIndex struct {
+	conn   *redis.Connection
+	prefix string
+}
+
+// NewRedisIndex ...
+func NewRedisIndex(conn *redis.Connection, prefix string) *RedisIndex {
+	return &RedisIndex{conn: conn, prefix: prefix}
+}
+
+// idKey returns the key holding the hash of the image identified by id
+func (index *RedisIndex) idKey(id string) string {
+	return index.prefix + ":id:" + id
+}
+
+// Add ...
+func (index *RedisIndex) Add(id string, hash uint64, ttl time.Duration) error {
+	for _, key := range bandKeys(index.prefix, hash) {
+		if err := index.conn.AddToSet(key, member(id, hash), ttl); err != nil {
+			return err
+		}
+	}
+	return index.conn.SetKeyWithExpiry(index.idKey(id), strconv.FormatUint(hash, 16), ttl)
+}
+
+// Remove ...
+func (index *RedisIndex) Remove(id string) error {
+	rawHash, err := index.conn.GetKey(index.idKey(id))
+	if err != nil || rawHash == "" {
+		return err
+	}
+	hash, err := strconv.ParseUint(rawHash, 16, 64)
+	if err != nil {
+		return err
+	}
+	for _, key := range bandKeys(index.prefix, hash) {
+		if err := index.conn.RemoveFromSet(key, member(id, hash)); err != nil {
+			return err
+		}
+	}
+	_, err = index.conn.DeleteKeys(index.idKey(id))
+	return err
+}
+
+// Clear ...
+func (index *RedisIndex) Clear() error {
+	_, err := index.conn.DeleteMatching(index.prefix + ":*")
+	return err
+}
+
+// Nearest ...
+func (index *RedisIndex) Nearest(hash uint64, maxDistance int) (string, int, error) {
+	members, err := index.conn.UnionSets(bandKeys(index.prefix, hash)...)
+	if err != nil {
+		return "", 0, err
+	}
+	return nearest(members, hash, maxDistance)
+}
+
+// MemoryIndex keeps the bands in memory
+type MemoryIndex struct {
+	mutex  sync.Mutex
+	prefix string
+	bands  map[string]map[string]time.Time
+	now    func() time.Time
+}
+
+// NewMemoryIndex ...
+func NewMemoryIndex(prefix string) *MemoryIndex {
+	return &MemoryIndex{
+		prefix: prefix,
+		bands:  make(map[string]map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Add ...
+func (index *MemoryIndex) Add(id string, hash uint64, ttl time.Duration) error {
+	var expires time.Time
+	if ttl > 0 {
+		expires = index.now().Add(ttl)
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	for _, key := range bandKeys(index.prefix, hash) {
+		if index.bands[key] == nil {
+			index.bands[key] = make(map[string]time.Time)
+		}
+		index.bands[key][member(id, hash)] = expires
+	}
+	return nil
+}
+
+// Nearest ...
+func (index *MemoryIndex) Nearest(hash uint64, maxDistance int) (string, int, error) {
+	now := index.now()
+	var members []string
+
+	index.mutex.Lock()
+	for _, key := range bandKeys(index.prefix, hash) {
+		for current, expires := range index.bands[key] {
+			if !expires.IsZero() && now.After(expires) {
+				delete(index.bands[key], current)
+				continue
+			}
+			members = append(members, current)
+		}
+	}
+	index.mutex.Unlock()
+
+	return nearest(members, hash, maxDistance)
+}
+
+// Remove ...
+func (index *MemoryIndex) Remove(id string) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	for key, members := range index.bands {
+		for current := range members {
+			if parts := strings.SplitN(current, ":", 2); len(parts) == 2 && parts[1] == id {
+				delete(members, current)
+			}
+		}
+		if len(members) == 0 {
+			delete(index.bands, key)
+		}
+	}
+	return nil
+}
+
+// Clear ...
+func (index *MemoryIndex) Clear() error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.bands = make(map[string]map[string]time.Time)
+	return nil
+}
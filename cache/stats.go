@@ -0,0 +1,58 @@
+package cache
+
+import "sync/atomic"
+
+// Stats counts how the cache is used by this instance
+type Stats struct {
+	hits           int64
+	misses         int64
+	errors         int64
+	sets           int64
+	nearDuplicates int64
+	coalesced      int64
+}
+
+// StatsSnapshot is a copy of the counters at a point in time
+type StatsSnapshot struct {
+	Hits           int64   `json:"hits"`
+	Misses         int64   `json:"misses"`
+	Errors         int64   `json:"errors"`
+	Sets           int64   `json:"sets"`
+	NearDuplicates int64   `json:"near_duplicates"`
+	Coalesced      int64   `json:"coalesced"`
+	HitRatio       float64 `json:"hit_ratio"`
+}
+
+// Hit records a lookup answered from the cache
+func (stats *Stats) Hit() { atomic.AddInt64(&stats.hits, 1) }
+
+// Miss records a lookup the cache could not answer
+func (stats *Stats) Miss() { atomic.AddInt64(&stats.misses, 1) }
+
+// Error records a failed cache operation
+func (stats *Stats) Error() { atomic.AddInt64(&stats.errors, 1) }
+
+// Set records a value written to the cache
+func (stats *Stats) Set() { atomic.AddInt64(&stats.sets, 1) }
+
+// NearDuplicate records a detection reused from a near duplicate image
+func (stats *Stats) NearDuplicate() { atomic.AddInt64(&stats.nearDuplicates, 1) }
+
+// Coalesced records a detection shared with a concurrent request
+func (stats *Stats) Coalesced() { atomic.AddInt64(&stats.coalesced, 1) }
+
+// Snapshot ...
+func (stats *Stats) Snapshot() StatsSnapshot {
+	snapshot := StatsSnapshot{
+		Hits:           atomic.LoadInt64(&stats.hits),
+		Misses:         atomic.LoadInt64(&stats.misses),
+		Errors:         atomic.LoadInt64(&stats.errors),
+		Sets:           atomic.LoadInt64(&stats.sets),
+		NearDuplicates: atomic.LoadInt64(&stats.nearDuplicates),
+		Coalesced:      atomic.LoadInt64(&stats.coalesced),
+	}
+	if lookups := snapshot.Hits + snapshot.Misses; lookups > 0 {
+		snapshot.HitRatio = float64(snapshot.Hits) / float64(lookups)
+	}
+	return snapshot
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path"
+	"testing"
+
+	models "github.com/rohith2506/facedetect/models"
+)
+
+func TestDetectionKey(t *testing.T) {
+	app := newTestApp(t)
+	annotated := renderOptions{Style: models.StyleAnnotated, Extension: ".jpg"}
+	anonymized := renderOptions{Style: models.StyleAnonymized, Extension: ".jpg"}
+	key := app.detectionKey(testImageHash, annotated)
+	if key == app.detectionKey(testImageHash, anonymized) {
+		t.Fatalf("expected the render options to change the key %s", key)
+	}
+
+	for _, pattern := range []string{
+		app.imageDetectionPattern(testImageHash),
+		app.modelDetectionPattern(models.MTCNNName, models.MTCNNVersion),
+	} {
+		if matched, _ := path.Match(pattern, key); !matched {
+			t.Fatalf("expected %s to match %s", pattern, key)
+		}
+	}
+	if matched, _ := path.Match(app.modelDetectionPattern(models.MTCNNName, "0"), key); matched {
+		t.Fatalf("expected another model version not to match %s", key)
+	}
+
+	// applications sharing a cache under other namespaces keep apart
+	other := newTestApp(t)
+	other.Namespace = "staging"
+	if other.detectionKey(testImageHash, annotated) == key {
+		t.Fatalf("expected the namespace to change the key %s", key)
+	}
+	if matched, _ := path.Match(other.imageDetectionPattern(testImageHash), key); matched {
+		t.Fatalf("expected another namespace not to match %s", key)
+	}
+}
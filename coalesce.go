@@ -0,0 +1,45 @@
+package main
+
+import "time"
+
+// A detection lock outlives the slowest detection, so that it is only taken
+// over once its holder is gone. Waiters give up after lockWait and run the
+// detection themselves.
+const (
+	lockTTL          = time.Minute
+	lockWait         = 45 * time.Second
+	lockPollInterval = 250 * time.Millisecond
+)
+
+// lockKey returns the key of the lock guarding the detection cached under cacheKey
+func lockKey(cacheKey string) string {
+	return cacheKey + ":lock"
+}
+
+// lockDetection takes the detection lock shared with the other instances. The release
+// function is nil when another instance holds the lock. Without a shared cache there is
+// nobody to coordinate with, the lock is always granted.
+func (app *App) lockDetection(cacheKey string) (func() error, error) {
+	if app.Locker == nil {
+		return func() error { return nil }, nil
+	}
+	return app.Locker.TryLock(lockKey(cacheKey), lockTTL)
+}
+
+// waitForDetection waits for the instance holding the lock to cache its detection. It
+// returns nil when the lock is released without a result or when waiting takes too long.
+func (app *App) waitForDetection(cacheKey string) *RedisOutput {
+	deadline := time.Now().Add(lockWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(lockPollInterval)
+		if output := app.getSignedDetection(cacheKey); output != nil {
+			return output
+		}
+		held, err := app.Locker.Held(lockKey(cacheKey))
+		if err != nil || !held {
+			// the holder failed, look one last time in case it finished in between
+			return app.getSignedDetection(cacheKey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/rohith2506/facedetect/cache"
+	config "github.com/rohith2506/facedetect/config"
+	models "github.com/rohith2506/facedetect/models"
+	mtcnntest "github.com/rohith2506/facedetect/models/mtcnntest"
+	redis "github.com/rohith2506/facedetect/redis"
+	redistest "github.com/rohith2506/facedetect/redis/redistest"
+	utilities "github.com/rohith2506/facedetect/utilities"
+)
+
+// newSharedApp returns an application sharing its cache and its locks through the redis server,
+// as another instance of the service would, along with its detector
+func newSharedApp(t *testing.T, server *redistest.Server) (*App, *mtcnntest.Server) {
+	conn := redis.NewConnection(redis.Config{Addr: server.Addr})
+	detector := newDetector(t)
+	app := newTestApp(t)
+	app.Detector = models.NewMTCNN(detector.Addr(), time.Second)
+	app.Cache = cache.NewRedis(conn)
+	app.Index = cache.NewRedisIndex(conn, app.similarityPrefix())
+	app.Locker = cache.NewRedisLocker(conn)
+	app.CacheMode = config.CacheRedis
+	return app, detector
+}
+
+func newLockServer(t *testing.T) *redistest.Server {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.RegisterScript(redis.DeleteIfEqualsHash(), redistest.CompareAndDelete)
+	return server
+}
+
+// detectInBackground runs the detection of the waiting instance, the result comes on the channel
+func detectInBackground(app *App, imageData []byte) chan *RedisOutput {
+	result := make(chan *RedisOutput, 1)
+	go func() {
+		output, err := app.detectFaces(imageData, ".jpg", nil)
+		if err != nil {
+			output = nil
+		}
+		result <- output
+	}()
+	return result
+}
+
+// waitForWaiter returns once the waiting instance missed the cache and found the lock taken
+func waitForWaiter(t *testing.T, app *App) {
+	deadline := time.Now().Add(10 * time.Second)
+	for app.stats.Snapshot().Misses == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the waiter never looked the detection up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the lock is tried right after the miss
+	time.Sleep(lockPollInterval / 2)
+}
+
+func readElon(t *testing.T) []byte {
+	imageData, err := ioutil.ReadFile("test_images/elon.jpg")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return imageData
+}
+
+func TestWaitForLockHolder(t *testing.T) {
+	server := newLockServer(t)
+	holder, _ := newSharedApp(t, server)
+	waiter, detector := newSharedApp(t, server)
+	imageData := readElon(t)
+	imageHash := utilities.GetBytesHash(imageData)
+	cacheKey := holder.detectionKey(imageHash, renderOptions{Style: models.StyleAnnotated, Extension: ".jpg"})
+
+	release, err := holder.lockDetection(cacheKey)
+	if err != nil || release == nil {
+		t.Fatalf("expected the lock, got %v", err)
+	}
+	result := detectInBackground(waiter, imageData)
+
+	// the holder caches its detection while the waiter polls
+	waitForWaiter(t, waiter)
+	cached, _ := json.Marshal(RedisOutput{ImageKey: imageHash + ".jpg", Width: 10, Height: 10})
+	if err := holder.Cache.Set(cacheKey, cached, 0); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	release()
+
+	output := <-result
+	if output == nil || output.Width != 10 {
+		t.Fatalf("expected the detection of the holder, got %+v", output)
+	}
+	if requests := len(detector.Requests()); requests != 0 {
+		t.Fatalf("expected the waiter not to run the detector, got %d requests", requests)
+	}
+	if coalesced := waiter.stats.Snapshot().Coalesced; coalesced != 1 {
+		t.Fatalf("expected a coalesced detection, got %d", coalesced)
+	}
+}
+
+func TestLockHolderFails(t *testing.T) {
+	server := newLockServer(t)
+	holder, _ := newSharedApp(t, server)
+	waiter, detector := newSharedApp(t, server)
+	imageData := readElon(t)
+	cacheKey := holder.detectionKey(utilities.GetBytesHash(imageData), renderOptions{Style: models.StyleAnnotated, Extension: ".jpg"})
+
+	release, err := holder.lockDetection(cacheKey)
+	if err != nil || release == nil {
+		t.Fatalf("expected the lock, got %v", err)
+	}
+	result := detectInBackground(waiter, imageData)
+
+	// the holder gives up without caching anything
+	waitForWaiter(t, waiter)
+	release()
+
+	output := <-result
+	if output == nil || len(output.Landmarks) != 1 {
+		t.Fatalf("expected the waiter to run the detection, got %+v", output)
+	}
+	if requests := len(detector.Requests()); requests != 1 {
+		t.Fatalf("expected the waiter to run the detector once, got %d requests", requests)
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	server := newLockServer(t)
+	var (
+		mutex sync.Mutex
+		now   = time.Now()
+	)
+	server.SetNow(func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	})
+	holder, _ := newSharedApp(t, server)
+	waiter, detector := newSharedApp(t, server)
+	imageData := readElon(t)
+	cacheKey := holder.detectionKey(utilities.GetBytesHash(imageData), renderOptions{Style: models.StyleAnnotated, Extension: ".jpg"})
+
+	// the holder crashes and never releases its lock
+	if release, err := holder.lockDetection(cacheKey); err != nil || release == nil {
+		t.Fatalf("expected the lock, got %v", err)
+	}
+	result := detectInBackground(waiter, imageData)
+
+	waitForWaiter(t, waiter)
+	mutex.Lock()
+	now = now.Add(lockTTL)
+	mutex.Unlock()
+
+	output := <-result
+	if output == nil || len(output.Landmarks) != 1 {
+		t.Fatalf("expected the waiter to run the detection, got %+v", output)
+	}
+	if requests := len(detector.Requests()); requests != 1 {
+		t.Fatalf("expected the waiter to run the detector once, got %d requests", requests)
+	}
+}
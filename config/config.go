@@ -0,0 +1,440 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	cache "github.com/rohith2506/facedetect/cache"
+	fetch "github.com/rohith2506/facedetect/fetch"
+	models "github.com/rohith2506/facedetect/models"
+	redis "github.com/rohith2506/facedetect/redis"
+	s3 "github.com/rohith2506/facedetect/s3"
+	storage "github.com/rohith2506/facedetect/storage"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The configuration file is given with -config or CONFIG_FILE
+const (
+	fileFlag = "config"
+	fileEnv  = "CONFIG_FILE"
+)
+
+// Image store backends
+const (
+	StoreS3    = "s3"
+	StoreLocal = "local"
+)
+
+// Detection cache modes, tiered puts a local lru in front of redis
+const (
+	CacheRedis  = "redis"
+	CacheMemory = "memory"
+	CacheTiered = "tiered"
+)
+
+const redacted = "<redacted>"
+
+// MaxHashDistance bounds the distance between the perceptual hashes of near duplicates,
+// the similarity index misses some of the further ones
+const MaxHashDistance = cache.MaxDistance
+
+// Config is the configuration of the service. Every setting is read, in increasing
+// order of precedence, from the defaults, the yaml file, the environment variable
+// given by its env tag and the command line flag given by its flag tag.
+type Config struct {
+	Environment   string       `yaml:"environment" env:"ENVIRONMENT" flag:"environment" usage:"name of the deployment environment"`
+	Server        ServerConfig `yaml:"server"`
+	Store         StoreConfig  `yaml:"store"`
+	Redis         RedisConfig  `yaml:"redis"`
+	S3            S3Config     `yaml:"s3"`
+	MTCNN         MTCNNConfig  `yaml:"mtcnn"`
+	Cache         CacheConfig  `yaml:"cache"`
+	Fetch         FetchConfig  `yaml:"fetch"`
+	AdminToken    string       `yaml:"admin_token" env:"ADMIN_TOKEN" flag:"admin-token" usage:"bearer token of the admin api, which is disabled without it"`
+	WebhookSecret string       `yaml:"webhook_secret" env:"WEBHOOK_SECRET" flag:"webhook-secret" usage:"secret signing the job callbacks, which are disabled without it"`
+}
+
+// ServerConfig describes the http server
+type ServerConfig struct {
+	Addr               string        `yaml:"addr" env:"SERVER_ADDR" flag:"addr" usage:"address the http server listens on"`
+	ReadTimeout        time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT" flag:"read-timeout" usage:"maximum duration for reading a request"`
+	WriteTimeout       time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" flag:"write-timeout" usage:"maximum duration for writing a response"`
+	MaxHeaderBytes     int           `yaml:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES" flag:"max-header-bytes" usage:"maximum size of the request headers"`
+	MaxMultipartMemory int64         `yaml:"max_multipart_memory" env:"MAX_MULTIPART_MEMORY" flag:"max-multipart-memory" usage:"bytes of a multipart form kept in memory, the rest goes to temporary files"`
+}
+
+// StoreConfig describes where the rendered images are kept
+type StoreConfig struct {
+	Backend     string        `yaml:"backend" env:"IMAGE_STORE" flag:"image-store" usage:"image store, s3 or local"`
+	LocalDir    string        `yaml:"local_dir" env:"LOCAL_STORE_DIR" flag:"local-store-dir" usage:"directory of the local image store"`
+	PublicURL   string        `yaml:"public_url" env:"PUBLIC_URL" flag:"public-url" usage:"public url of the service, prefixed to the local image urls"`
+	URLExpiry   time.Duration `yaml:"url_expiry" env:"IMAGE_URL_EXPIRY" flag:"image-url-expiry" usage:"lifetime of the image urls"`
+	LocalSecret string        `yaml:"local_secret" env:"LOCAL_STORE_SECRET" flag:"local-store-secret" usage:"secret signing the local image urls, random when not set"`
+	Retention   time.Duration `yaml:"retention" env:"IMAGE_RETENTION" flag:"image-retention" usage:"age at which the rendered images and their detections are removed, 0 keeps them forever"`
+}
+
+// RedisConfig describes how to reach the redis server
+type RedisConfig struct {
+	Addr     string `yaml:"addr" env:"REDIS_ADDR" flag:"redis-addr" usage:"address of the redis server"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD" flag:"redis-password" usage:"password of the redis server"`
+	DB       int    `yaml:"db" env:"REDIS_DB" flag:"redis-db" usage:"redis database number"`
+	TLS      bool   `yaml:"tls" env:"REDIS_TLS" flag:"redis-tls" usage:"connect to redis over tls"`
+}
+
+// S3Config describes the s3 bucket of the images, see s3.Config
+type S3Config struct {
+	Region       string `yaml:"region" env:"AWS_REGION" flag:"s3-region" usage:"aws region of the bucket"`
+	Endpoint     string `yaml:"endpoint" env:"S3_ENDPOINT" flag:"s3-endpoint" usage:"endpoint of an s3 compatible service"`
+	PathStyle    bool   `yaml:"path_style" env:"S3_FORCE_PATH_STYLE" flag:"s3-path-style" usage:"use path style bucket addressing"`
+	Bucket       string `yaml:"bucket" env:"S3_BUCKET" flag:"s3-bucket" usage:"bucket of the images"`
+	KeyPrefix    string `yaml:"key_prefix" env:"S3_KEY_PREFIX" flag:"s3-key-prefix" usage:"prefix of the object keys"`
+	SSE          string `yaml:"sse" env:"S3_SSE" flag:"s3-sse" usage:"server side encryption, AES256 or aws:kms"`
+	KMSKeyID     string `yaml:"kms_key_id" env:"S3_SSE_KMS_KEY_ID" flag:"s3-kms-key-id" usage:"kms key of the server side encryption"`
+	StorageClass string `yaml:"storage_class" env:"S3_STORAGE_CLASS" flag:"s3-storage-class" usage:"storage class of the images"`
+}
+
+// MTCNNConfig describes how to reach the python detector
+type MTCNNConfig struct {
+	Host    string        `yaml:"host" env:"MTCNN_HOST" flag:"mtcnn-host" usage:"host of the mtcnn detector"`
+	Port    int           `yaml:"port" env:"MTCNN_PORT" flag:"mtcnn-port" usage:"port of the mtcnn detector"`
+	Timeout time.Duration `yaml:"timeout" env:"MTCNN_TIMEOUT" flag:"mtcnn-timeout" usage:"maximum duration of a detection"`
+}
+
+// CacheConfig describes the detection cache
+type CacheConfig struct {
+	Mode         string        `yaml:"mode" env:"CACHE_MODE" flag:"cache-mode" usage:"detection cache, redis, memory or tiered"`
+	LRUEntries   int           `yaml:"lru_entries" env:"CACHE_LRU_ENTRIES" flag:"cache-lru-entries" usage:"maximum number of detections in the local lru"`
+	LRUBytes     int64         `yaml:"lru_bytes" env:"CACHE_LRU_BYTES" flag:"cache-lru-bytes" usage:"maximum size of the local lru"`
+	LRUTTL       time.Duration `yaml:"lru_ttl" env:"CACHE_LRU_TTL" flag:"cache-lru-ttl" usage:"lifetime of the detections in the local lru, 0 keeps them until evicted"`
+	Namespace    string        `yaml:"namespace" env:"CACHE_NAMESPACE" flag:"cache-namespace" usage:"prefix of every cache key"`
+	TTL          time.Duration `yaml:"ttl" env:"CACHE_TTL" flag:"cache-ttl" usage:"lifetime of the cached detections, 0 keeps them until evicted"`
+	HashDistance int           `yaml:"hash_distance" env:"PHASH_MAX_DISTANCE" flag:"phash-max-distance" usage:"largest perceptual hash distance of a near duplicate, 0 disables the lookup"`
+	URLFreshness time.Duration `yaml:"url_freshness" env:"URL_CACHE_FRESHNESS" flag:"url-cache-freshness" usage:"how long a fetched image url is trusted without revalidation"`
+}
+
+// FetchConfig describes how the user supplied urls are fetched, see fetch.Config. The networks
+// are comma separated, DenyCIDRs adds to fetch.DefaultDeny while AllowCIDRs opens exceptions in it.
+type FetchConfig struct {
+	AllowCIDRs   string        `yaml:"allow_cidrs" env:"FETCH_ALLOW_CIDRS" flag:"fetch-allow-cidrs" usage:"networks reachable even though they are denied"`
+	DenyCIDRs    string        `yaml:"deny_cidrs" env:"FETCH_DENY_CIDRS" flag:"fetch-deny-cidrs" usage:"networks denied on top of the private and reserved ones"`
+	Timeout      time.Duration `yaml:"timeout" env:"FETCH_TIMEOUT" flag:"fetch-timeout" usage:"maximum duration of a fetch"`
+	MaxRedirects int           `yaml:"max_redirects" env:"FETCH_MAX_REDIRECTS" flag:"fetch-max-redirects" usage:"maximum number of redirects followed by a fetch"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	port, _ := strconv.Atoi(models.Port)
+	return &Config{
+		Environment: "default",
+		Server: ServerConfig{
+			Addr:               ":8000",
+			ReadTimeout:        10 * time.Second,
+			WriteTimeout:       10 * time.Second,
+			MaxHeaderBytes:     1 << 20,
+			MaxMultipartMemory: 8 << 20, // 8 MiB
+		},
+		Store: StoreConfig{
+			Backend:   StoreS3,
+			LocalDir:  "/tmp/images/store/",
+			URLExpiry: storage.DefaultURLExpiry,
+		},
+		Redis: RedisConfig{Addr: redis.DefaultAddr},
+		S3:    S3Config{Bucket: s3.DefaultBucket},
+		MTCNN: MTCNNConfig{
+			Host:    models.Host,
+			Port:    port,
+			Timeout: models.DefaultTimeout,
+		},
+		Cache: CacheConfig{
+			Mode:         CacheRedis,
+			LRUEntries:   1000,
+			LRUBytes:     64 << 20, // 64 MiB
+			LRUTTL:       10 * time.Minute,
+			Namespace:    "facedetect",
+			TTL:          30 * 24 * time.Hour,
+			HashDistance: 3,
+			URLFreshness: time.Hour,
+		},
+		Fetch: FetchConfig{
+			Timeout:      fetch.DefaultTimeout,
+			MaxRedirects: fetch.DefaultMaxRedirects,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the file given by -config or CONFIG_FILE,
+// the environment and the command line arguments, then validates it. The settings are
+// registered as flags of flags, which may hold flags of its own.
+func Load(flags *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := Default()
+
+	// the flags are applied last, once the file and the environment are read
+	given := make(map[string]string)
+	file := flags.String(fileFlag, "", "yaml configuration file, defaults to "+fileEnv)
+	err := visit(reflect.ValueOf(config).Elem(), func(field reflect.Value, tag reflect.StructTag) error {
+		name := tag.Get("flag")
+		flags.Var(&flagValue{
+			name:         name,
+			defaultValue: format(field),
+			isBool:       field.Kind() == reflect.Bool,
+			given:        given,
+		}, name, tag.Get("usage"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *file
+	if path == "" {
+		path, _ = lookupEnv(fileEnv)
+	}
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(content, config); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %v", path, err)
+		}
+	}
+
+	err = visit(reflect.ValueOf(config).Elem(), func(field reflect.Value, tag reflect.StructTag) error {
+		if raw, found := lookupEnv(tag.Get("env")); found && raw != "" {
+			if err := parse(field, raw); err != nil {
+				return fmt.Errorf("%s: %v", tag.Get("env"), err)
+			}
+		}
+		if raw, found := given[tag.Get("flag")]; found {
+			if err := parse(field, raw); err != nil {
+				return fmt.Errorf("-%s: %v", tag.Get("flag"), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+// Validate ...
+func (config *Config) Validate() error {
+	switch {
+	case config.Server.Addr == "":
+		return errors.New("server address is required")
+	case config.Server.ReadTimeout < 0 || config.Server.WriteTimeout < 0:
+		return errors.New("server timeouts must not be negative")
+	case config.Server.MaxHeaderBytes <= 0 || config.Server.MaxMultipartMemory <= 0:
+		return errors.New("server size limits must be positive")
+	case config.Store.URLExpiry <= 0:
+		return errors.New("image url expiry must be positive")
+	case config.Redis.Addr == "":
+		return errors.New("redis address is required")
+	case config.Redis.DB < 0:
+		return errors.New("redis database must not be negative")
+	case config.MTCNN.Host == "":
+		return errors.New("mtcnn host is required")
+	case config.MTCNN.Port <= 0 || config.MTCNN.Port > 65535:
+		return fmt.Errorf("mtcnn port must be between 1 and 65535, got %d", config.MTCNN.Port)
+	case config.MTCNN.Timeout <= 0:
+		return errors.New("mtcnn timeout must be positive")
+	case config.Cache.Namespace == "" || strings.ContainsAny(config.Cache.Namespace, "*?[]\\"):
+		return fmt.Errorf("cache namespace must be set and must not contain glob characters, got %q", config.Cache.Namespace)
+	case config.Cache.TTL < 0 || config.Cache.URLFreshness < 0:
+		return errors.New("cache lifetimes must not be negative")
+	case config.Cache.HashDistance < 0 || config.Cache.HashDistance > MaxHashDistance:
+		return fmt.Errorf("perceptual hash distance must be between 0 and %d, got %d", MaxHashDistance, config.Cache.HashDistance)
+	case config.Cache.Mode != CacheRedis && config.Cache.Mode != CacheMemory && config.Cache.Mode != CacheTiered:
+		return fmt.Errorf("unknown cache mode %q, possible modes are [%s, %s, %s]", config.Cache.Mode, CacheRedis, CacheMemory, CacheTiered)
+	case config.Cache.LRUEntries < 0 || config.Cache.LRUBytes < 0 || config.Cache.LRUTTL < 0:
+		return errors.New("cache lru limits must not be negative")
+	case config.Fetch.Timeout <= 0:
+		return errors.New("fetch timeout must be positive")
+	case config.Fetch.MaxRedirects < 0:
+		return errors.New("fetch redirects must not be negative")
+	case config.Store.Retention < 0:
+		return errors.New("image retention must not be negative")
+	}
+	if _, err := parseCIDRs(config.Fetch.AllowCIDRs); err != nil {
+		return fmt.Errorf("invalid fetch allow list: %v", err)
+	}
+	if _, err := parseCIDRs(config.Fetch.DenyCIDRs); err != nil {
+		return fmt.Errorf("invalid fetch deny list: %v", err)
+	}
+	switch config.Store.Backend {
+	case StoreS3:
+		if err := config.S3Config().Validate(); err != nil {
+			return fmt.Errorf("invalid s3 configuration: %v", err)
+		}
+	case StoreLocal:
+		if config.Store.LocalDir == "" {
+			return errors.New("local store directory is required")
+		}
+	default:
+		return fmt.Errorf("unknown image store %q, possible stores are [%s, %s]", config.Store.Backend, StoreS3, StoreLocal)
+	}
+	return nil
+}
+
+// RedisConfig ...
+func (config *Config) RedisConfig() redis.Config {
+	return redis.Config{
+		Addr:     config.Redis.Addr,
+		Password: config.Redis.Password,
+		DB:       config.Redis.DB,
+		TLS:      config.Redis.TLS,
+	}
+}
+
+// S3Config ...
+func (config *Config) S3Config() s3.Config {
+	return s3.Config{
+		Region:       config.S3.Region,
+		Endpoint:     config.S3.Endpoint,
+		PathStyle:    config.S3.PathStyle,
+		Bucket:       config.S3.Bucket,
+		KeyPrefix:    config.S3.KeyPrefix,
+		SSE:          config.S3.SSE,
+		KMSKeyID:     config.S3.KMSKeyID,
+		StorageClass: config.S3.StorageClass,
+		URLExpiry:    config.Store.URLExpiry,
+	}
+}
+
+// FetchConfig returns the address checks and the limits of the fetches, the size limit is up to the caller
+func (config *Config) FetchConfig() fetch.Config {
+	allow, _ := parseCIDRs(config.Fetch.AllowCIDRs)
+	deny, _ := parseCIDRs(config.Fetch.DenyCIDRs)
+	return fetch.Config{
+		Allow:        allow,
+		Deny:         append(append([]*net.IPNet{}, fetch.DefaultDeny...), deny...),
+		Timeout:      config.Fetch.Timeout,
+		MaxRedirects: config.Fetch.MaxRedirects,
+	}
+}
+
+// parseCIDRs parses a comma separated list of networks
+func parseCIDRs(raw string) ([]*net.IPNet, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	return fetch.ParseCIDRs(strings.Split(raw, ","))
+}
+
+// DetectorAddr returns the address of the mtcnn detector
+func (config *Config) DetectorAddr() string {
+	return net.JoinHostPort(config.MTCNN.Host, strconv.Itoa(config.MTCNN.Port))
+}
+
+// Print writes the configuration as yaml, with the secrets redacted
+func (config *Config) Print(w io.Writer) error {
+	printed := *config
+	secrets := []*string{&printed.Redis.Password, &printed.Store.LocalSecret, &printed.AdminToken, &printed.WebhookSecret}
+	for _, secret := range secrets {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	out, err := yaml.Marshal(&printed)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// visit calls fn on every setting of the struct, walking down the sections
+func visit(value reflect.Value, fn func(field reflect.Value, tag reflect.StructTag) error) error {
+	for i := 0; i < value.NumField(); i++ {
+		field, tag := value.Field(i), value.Type().Field(i).Tag
+		if field.Kind() == reflect.Struct {
+			if err := visit(field, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(field, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse sets the field from its textual form
+func parse(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	case reflect.Int, reflect.Int64:
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(value)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// format returns the textual form of the field, as accepted by parse
+func format(field reflect.Value) string {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(field.Int()).String()
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// flagValue records the value given on the command line, so that it can be applied after the environment
+type flagValue struct {
+	name         string
+	defaultValue string
+	isBool       bool
+	given        map[string]string
+}
+
+func (value *flagValue) String() string {
+	if value == nil {
+		return ""
+	}
+	if raw, found := value.given[value.name]; found {
+		return raw
+	}
+	return value.defaultValue
+}
+
+func (value *flagValue) Set(raw string) error {
+	value.given[value.name] = raw
+	return nil
+}
+
+// IsBoolFlag allows "-redis-tls" without "=true"
+func (value *flagValue) IsBoolFlag() bool {
+	return value.isBool
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, found := env[name]
+		return value, found
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "facedetect.yaml")
+	content := "server:\n  addr: :9000\n  read_timeout: 5s\nredis:\n  addr: redis:6379\n  db: 1\nmtcnn:\n  port: 4000\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"CONFIG_FILE": path, "REDIS_DB": "2", "MTCNN_PORT": "5000", "CACHE_MODE": "tiered", "FETCH_ALLOW_CIDRS": "10.1.0.0/16, 10.2.0.0/16"}
+	args := []string{"-mtcnn-port", "6000", "-redis-tls"}
+	config, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), args, lookup(env))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if config.Server.Addr != ":9000" || config.Server.ReadTimeout != 5*time.Second || config.Server.WriteTimeout != 10*time.Second {
+		t.Fatalf("unexpected server config: %+v", config.Server)
+	}
+	if config.Redis.Addr != "redis:6379" || config.Redis.DB != 2 || !config.Redis.TLS {
+		t.Fatalf("unexpected redis config: %+v", config.Redis)
+	}
+	if config.DetectorAddr() != "localhost:6000" {
+		t.Fatalf("unexpected detector address %s", config.DetectorAddr())
+	}
+	if config.Cache.Mode != CacheTiered || config.Cache.LRUEntries != 1000 {
+		t.Fatalf("unexpected cache config: %+v", config.Cache)
+	}
+	if fetchConfig := config.FetchConfig(); len(fetchConfig.Allow) != 2 || len(fetchConfig.Deny) == 0 || fetchConfig.Timeout != 10*time.Second {
+		t.Fatalf("unexpected fetch config: %+v", fetchConfig)
+	}
+
+	env["MTCNN_PORT"] = "none"
+	if _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, lookup(env)); err == nil {
+		t.Fatal("expected an error for an invalid port")
+	}
+	if _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-image-store", "disk"}, lookup(nil)); err == nil {
+		t.Fatal("expected an error for an unknown image store")
+	}
+	for _, invalid := range []map[string]string{
+		{"PHASH_MAX_DISTANCE": "4"},
+		{"CACHE_NAMESPACE": "face*"},
+		{"CACHE_TTL": "-1h"},
+		{"CACHE_MODE": "disk"},
+		{"CACHE_LRU_BYTES": "-1"},
+		{"FETCH_DENY_CIDRS": "10.0.0.0/8,intranet"},
+		{"FETCH_TIMEOUT": "0s"},
+		{"IMAGE_RETENTION": "-720h"},
+	} {
+		if _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, lookup(invalid)); err == nil {
+			t.Fatalf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	config := Default()
+	config.Redis.Password = "hunter2"
+	config.AdminToken = "swordfish"
+	config.WebhookSecret = "letmein"
+	config.Store.LocalSecret = "opensesame"
+	var out bytes.Buffer
+	if err := config.Print(&out); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for _, secret := range []string{"hunter2", "swordfish", "letmein", "opensesame"} {
+		if strings.Contains(out.String(), secret) {
+			t.Fatalf("expected %s to be redacted:\n%s", secret, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "read_timeout: 10s") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	// the printed configuration can be loaded back
+	var loaded Config
+	if err := yaml.UnmarshalStrict(out.Bytes(), &loaded); err != nil || loaded.Server != config.Server {
+		t.Fatalf("printed config does not load back: %v", err)
+	}
+}
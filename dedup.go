@@ -0,0 +1,56 @@
+package main
+
+import (
+	"image"
+	"log"
+	"math"
+
+	cache "github.com/rohith2506/facedetect/cache"
+	models "github.com/rohith2506/facedetect/models"
+)
+
+// near duplicates whose aspect ratio differs more than this were cropped, their detections do not apply
+const maxAspectDifference = 0.02
+
+// findNearDuplicate looks for a cached detection made on an image perceptually
+// close to the current one. It returns the landmarks rescaled to bounds and the
+// hash of the near duplicate, or an empty hash when there is none.
+func (app *App) findNearDuplicate(perceptualHash uint64, imageHash string, bounds image.Rectangle, options renderOptions) ([]models.Detection, string) {
+	if app.HashDistance == 0 || bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, ""
+	}
+
+	duplicateHash, _, err := app.Index.Nearest(perceptualHash, app.HashDistance)
+	if err != nil {
+		if err != cache.ErrNotFound {
+			log.Printf("Perceptual hash lookup failed: %v", err)
+		}
+		return nil, ""
+	}
+	if duplicateHash == imageHash {
+		return nil, ""
+	}
+
+	// the detection may have expired or been deleted since it was indexed
+	duplicate, err := app.getExistingImage(app.detectionKey(duplicateHash, options))
+	if err != nil {
+		log.Printf("Cache get failed: %v", err)
+	}
+	if duplicate == nil || duplicate.Width == 0 || duplicate.Height == 0 {
+		return nil, ""
+	}
+
+	aspect := float64(bounds.Dx()) / float64(bounds.Dy())
+	duplicateAspect := float64(duplicate.Width) / float64(duplicate.Height)
+	if math.Abs(aspect-duplicateAspect)/duplicateAspect > maxAspectDifference {
+		return nil, ""
+	}
+
+	scaleX := float64(bounds.Dx()) / float64(duplicate.Width)
+	scaleY := float64(bounds.Dy()) / float64(duplicate.Height)
+	landmarks := make([]models.Detection, len(duplicate.Landmarks))
+	for i, landmark := range duplicate.Landmarks {
+		landmarks[i] = landmark.Scale(scaleX, scaleY)
+	}
+	return landmarks, duplicateHash
+}
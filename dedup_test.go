@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"testing"
+
+	models "github.com/rohith2506/facedetect/models"
+)
+
+func TestFindNearDuplicate(t *testing.T) {
+	app := newTestApp(t)
+	options := renderOptions{Style: models.StyleAnnotated, Extension: ".jpg"}
+	cached, _ := json.Marshal(RedisOutput{
+		Landmarks: []models.Detection{{FaceCoord: models.RectCoord{Row: 100, Col: 40, Width: 60, Height: 80}}},
+		ImageKey:  testImageHash + ".jpg",
+		Width:     400,
+		Height:    200,
+	})
+	if err := app.Cache.Set(app.detectionKey(testImageHash, options), cached, 0); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if err := app.Index.Add(testImageHash, 0xff00ff00ff00ff00, 0); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	landmarks, duplicateHash := app.findNearDuplicate(0xff00ff00ff00ff01, "fedcba9876543210fedcba9876543210", image.Rect(0, 0, 200, 100), options)
+	if duplicateHash != testImageHash || len(landmarks) != 1 {
+		t.Fatalf("expected a near duplicate, got %q with %v", duplicateHash, landmarks)
+	}
+	if bounds := landmarks[0].FaceCoord; bounds.Row != 50 || bounds.Col != 20 || bounds.Width != 30 || bounds.Height != 40 {
+		t.Fatalf("expected the landmarks to be rescaled, got %+v", bounds)
+	}
+
+	// a cropped copy has another aspect ratio
+	if _, duplicateHash := app.findNearDuplicate(0xff00ff00ff00ff01, "fedcba9876543210fedcba9876543210", image.Rect(0, 0, 200, 200), options); duplicateHash != "" {
+		t.Fatalf("expected no near duplicate for another aspect ratio, got %s", duplicateHash)
+	}
+}
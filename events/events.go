@@ -0,0 +1,176 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Stages of the detection pipeline
+const (
+	StageReceived  = "received"
+	StageDecoded   = "decoded"
+	StageCacheHit  = "cache_hit"
+	StageCacheMiss = "cache_miss"
+	StageDetected  = "detected"
+	StageRendered  = "rendered"
+	StageUploaded  = "uploaded"
+	StageDone      = "done"
+	StageFailed    = "failed"
+)
+
+const subscriberBuffer = 16
+
+// idBytes is the size of the random part of the stream ids
+const idBytes = 16
+
+// Event ...
+type Event struct {
+	ID    int         `json:"id"`
+	Stage string      `json:"stage"`
+	Data  interface{} `json:"data,omitempty"`
+	Time  time.Time   `json:"time"`
+}
+
+// Terminal tells whether no event follows this one
+func (event Event) Terminal() bool {
+	return event.Stage == StageDone || event.Stage == StageFailed
+}
+
+// Reporter publishes the pipeline stages of a single request or job
+type Reporter func(stage string, data interface{})
+
+// Report calls the reporter, doing nothing when it is nil
+func (reporter Reporter) Report(stage string, data interface{}) {
+	if reporter != nil {
+		reporter(stage, data)
+	}
+}
+
+type stream struct {
+	events      []Event
+	subscribers map[chan Event]struct{}
+	updatedAt   time.Time
+}
+
+// Broker fans out the events of every stream to its subscribers. The events
+// are kept for retention so that late or reconnecting subscribers catch up.
+type Broker struct {
+	mutex     sync.Mutex
+	streams   map[string]*stream
+	retention time.Duration
+}
+
+// NewBroker ...
+func NewBroker(retention time.Duration) *Broker {
+	return &Broker{
+		streams:   make(map[string]*stream),
+		retention: retention,
+	}
+}
+
+// expire drops the streams nobody listens to and which were not updated within the retention. The caller holds the mutex.
+func (broker *Broker) expire(now time.Time) {
+	for streamID, existing := range broker.streams {
+		if len(existing.subscribers) == 0 && now.Sub(existing.updatedAt) > broker.retention {
+			delete(broker.streams, streamID)
+		}
+	}
+}
+
+// getStream returns the stream with the given id, creating it if needed. The caller holds the mutex.
+func (broker *Broker) getStream(id string) *stream {
+	now := time.Now()
+	broker.expire(now)
+
+	current, found := broker.streams[id]
+	if !found {
+		current = &stream{subscribers: make(map[chan Event]struct{})}
+		broker.streams[id] = current
+	}
+	current.updatedAt = now
+	return current
+}
+
+// Open creates a stream under a random id of 128 bits and returns the id. The ids are
+// not chosen by the clients, so the stream of a request cannot be read by guessing it.
+func (broker *Broker) Open() (string, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.getStream(id)
+	return id, nil
+}
+
+// Opened tells whether the stream with the given id exists and has not expired
+func (broker *Broker) Opened(id string) bool {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.expire(time.Now())
+	_, found := broker.streams[id]
+	return found
+}
+
+// Publish sends an event to the subscribers of the stream
+func (broker *Broker) Publish(id string, stage string, data interface{}) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	current := broker.getStream(id)
+	event := Event{
+		ID:    len(current.events) + 1,
+		Stage: stage,
+		Data:  data,
+		Time:  time.Now().UTC(),
+	}
+	current.events = append(current.events, event)
+	for subscriber := range current.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// A stalled subscriber must not block the pipeline, it will
+			// catch up from the history when it reconnects
+		}
+	}
+}
+
+// Reporter returns a Reporter publishing to the stream, or nil when id is empty
+func (broker *Broker) Reporter(id string) Reporter {
+	if id == "" {
+		return nil
+	}
+	return func(stage string, data interface{}) {
+		broker.Publish(id, stage, data)
+	}
+}
+
+// Subscribe returns the events already published after lastEventID together
+// with a channel receiving the next ones. cancel must be called once done.
+func (broker *Broker) Subscribe(id string, lastEventID int) ([]Event, <-chan Event, func()) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	current := broker.getStream(id)
+	var history []Event
+	for _, event := range current.events {
+		if event.ID > lastEventID {
+			history = append(history, event)
+		}
+	}
+
+	subscriber := make(chan Event, subscriberBuffer)
+	current.subscribers[subscriber] = struct{}{}
+	cancel := func() {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+		delete(current.subscribers, subscriber)
+		current.updatedAt = time.Now()
+	}
+	return history, subscriber, cancel
+}
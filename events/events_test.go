@@ -0,0 +1,60 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBrokerReplaysHistory(t *testing.T) {
+	broker := NewBroker(time.Minute)
+	broker.Publish("request", StageReceived, nil)
+	broker.Publish("request", StageCacheMiss, nil)
+
+	history, _, cancel := broker.Subscribe("request", 0)
+	cancel()
+	if len(history) != 2 || history[0].Stage != StageReceived {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+
+	// A reconnecting subscriber only gets the events it missed
+	history, _, cancel = broker.Subscribe("request", 1)
+	cancel()
+	if len(history) != 1 || history[0].Stage != StageCacheMiss {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+}
+
+func TestBrokerStreamsEvents(t *testing.T) {
+	broker := NewBroker(time.Minute)
+	_, events, cancel := broker.Subscribe("job", 0)
+	defer cancel()
+
+	report := broker.Reporter("job")
+	report.Report(StageDone, "faces")
+	select {
+	case event := <-events:
+		if !event.Terminal() || event.Data != "faces" {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+
+	// A nil reporter is a no-op
+	broker.Reporter("").Report(StageDone, nil)
+}
+
+func TestBrokerOpen(t *testing.T) {
+	broker := NewBroker(time.Minute)
+	first, err := broker.Open()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	second, _ := broker.Open()
+	if len(first) != 2*idBytes || first == second {
+		t.Fatalf("expected distinct random ids, got %s and %s", first, second)
+	}
+	if !broker.Opened(first) || broker.Opened("request") {
+		t.Fatalf("expected only the opened stream to exist")
+	}
+}
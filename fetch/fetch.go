@@ -0,0 +1,367 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Default limits of a Fetcher
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxRedirects = 3
+	DefaultMaxBytes     = 8 << 20 // 8 MiB
+)
+
+// Errors returned by Fetch
+var (
+	ErrForbiddenScheme  = errors.New("url scheme is not allowed")
+	ErrForbiddenAddress = errors.New("address is not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrTooLarge         = errors.New("response exceeds the maximum size")
+	ErrNotImage         = errors.New("response is not an image")
+	ErrUnexpectedStatus = errors.New("unexpected upstream status")
+)
+
+// UpstreamError describes an answer of the upstream server which is not an image
+type UpstreamError struct {
+	URL         string
+	StatusCode  int
+	ContentType string
+	err         error
+}
+
+func (err *UpstreamError) Error() string {
+	return fmt.Sprintf("%v: %s answered %d with %q", err.err, err.URL, err.StatusCode, err.ContentType)
+}
+
+// Unwrap returns ErrUnexpectedStatus or ErrNotImage
+func (err *UpstreamError) Unwrap() error {
+	return err.err
+}
+
+// Validators identify the version of a resource already fetched, see Result
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Result is the outcome of a conditional fetch. When NotModified is set, the
+// resource did not change since the given validators and Data is empty.
+type Result struct {
+	Data        []byte
+	NotModified bool
+	Validators
+}
+
+// DefaultDeny holds the networks which must never be reached from user
+// supplied urls: loopback, private, link local (cloud metadata), carrier
+// grade nat, multicast and reserved ranges.
+var DefaultDeny = MustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// imageTypes are the types http.DetectContentType gives to the supported images
+var imageTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// Config describes what a Fetcher may reach
+type Config struct {
+	// Schemes defaults to http and https
+	Schemes []string
+	// Allow lists networks reachable even though they are part of Deny
+	Allow []*net.IPNet
+	// Deny lists the networks which cannot be reached, DefaultDeny when nil.
+	// Denying 0.0.0.0/0 and ::/0 restricts the fetches to the Allow list.
+	Deny         []*net.IPNet
+	Timeout      time.Duration
+	MaxRedirects int
+	MaxBytes     int64
+}
+
+// Fetcher downloads images from user supplied urls without giving access to
+// internal services. The addresses are checked when the connection is made,
+// after the name resolution, so a name resolving to a public address at
+// validation time and to an internal one later cannot get through.
+type Fetcher struct {
+	config Config
+	client *http.Client
+}
+
+// MustParseCIDRs parses the networks, it panics on invalid ones
+func MustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks, err := ParseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// ParseCIDRs parses networks in CIDR notation such as "10.0.0.0/8"
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// NewFetcher fills the zero values of config with the defaults
+func NewFetcher(config Config) *Fetcher {
+	if len(config.Schemes) == 0 {
+		config.Schemes = []string{"http", "https"}
+	}
+	if config.Deny == nil {
+		config.Deny = DefaultDeny
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxRedirects == 0 {
+		config.MaxRedirects = DefaultMaxRedirects
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = DefaultMaxBytes
+	}
+
+	fetcher := &Fetcher{config: config}
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: fetcher.control,
+	}
+	fetcher.client = &http.Client{
+		Timeout: config.Timeout,
+		Transport: &http.Transport{
+			// a proxy would make the connection on our behalf, out of reach of the checks
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   config.Timeout,
+			ResponseHeaderTimeout: config.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: fetcher.checkRedirect,
+	}
+	return fetcher
+}
+
+// NewClient returns an http client subject to the same address checks as the fetcher
+// built from config, which does not follow redirects. It suits the other requests sent
+// to user supplied urls, such as the job callbacks.
+func NewClient(config Config) *http.Client {
+	client := *NewFetcher(config).client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &client
+}
+
+// Allowed tells whether the address may be reached
+func (fetcher *Fetcher) Allowed(ip net.IP) bool {
+	for _, network := range fetcher.config.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, network := range fetcher.config.Deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// control runs right before every connection, with the resolved address
+func (fetcher *Fetcher) control(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !fetcher.Allowed(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+func (fetcher *Fetcher) checkScheme(target *url.URL) error {
+	for _, scheme := range fetcher.config.Schemes {
+		if strings.EqualFold(target.Scheme, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrForbiddenScheme, target.Scheme)
+}
+
+func (fetcher *Fetcher) checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) > fetcher.config.MaxRedirects {
+		return ErrTooManyRedirects
+	}
+	return fetcher.checkScheme(request.URL)
+}
+
+// Fetch downloads the image behind rawURL into memory
+func (fetcher *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	result, err := fetcher.FetchConditional(ctx, rawURL, Validators{})
+	if err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// FetchConditional downloads the image behind rawURL unless it did not change since the
+// version identified by known. Servers answering with a non 2xx status or a content type
+// other than an image fail with an *UpstreamError.
+func (fetcher *Fetcher) FetchConditional(ctx context.Context, rawURL string, known Validators) (*Result, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := fetcher.checkScheme(target); err != nil {
+		return nil, err
+	}
+	// credentials in the url would be sent to whoever the url points to
+	target.User = nil
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if known.ETag != "" {
+		request.Header.Set("If-None-Match", known.ETag)
+	}
+	if known.LastModified != "" {
+		request.Header.Set("If-Modified-Since", known.LastModified)
+	}
+	response, err := fetcher.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	result := &Result{Validators: Validators{
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}}
+	if response.StatusCode == http.StatusNotModified && (known.ETag != "" || known.LastModified != "") {
+		result.NotModified = true
+		// a 304 may leave out the validators which did not change
+		if result.ETag == "" {
+			result.ETag = known.ETag
+		}
+		if result.LastModified == "" {
+			result.LastModified = known.LastModified
+		}
+		return result, nil
+	}
+
+	upstreamErr := &UpstreamError{
+		URL:         target.String(),
+		StatusCode:  response.StatusCode,
+		ContentType: response.Header.Get("Content-Type"),
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		upstreamErr.err = ErrUnexpectedStatus
+		return nil, upstreamErr
+	}
+	if !isImageContentType(upstreamErr.ContentType) {
+		upstreamErr.err = ErrNotImage
+		return nil, upstreamErr
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, fetcher.config.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > fetcher.config.MaxBytes {
+		return nil, ErrTooLarge
+	}
+	if !isImage(data) {
+		return nil, ErrNotImage
+	}
+	result.Data = data
+	return result, nil
+}
+
+// isImageContentType accepts the image types as well as a missing or generic binary type,
+// the data itself is sniffed afterwards anyway
+func isImageContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "image/") || mediaType == "application/octet-stream"
+}
+
+// isImage sniffs the data, whatever the server claims it to be
+func isImage(data []byte) bool {
+	contentType := http.DetectContentType(data)
+	for _, imageType := range imageTypes {
+		if contentType == imageType {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeURL returns a canonical form of the url, so that spellings of the same
+// url share their cache entries: the scheme and the host are lowercased, default
+// ports, fragments and credentials are dropped and the query parameters are sorted.
+func NormalizeURL(rawURL string) (string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	target.Scheme = strings.ToLower(target.Scheme)
+	host, port := strings.ToLower(target.Hostname()), target.Port()
+	if (target.Scheme == "http" && port == "80") || (target.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	target.Host = host
+	target.User = nil
+	target.Fragment = ""
+	target.RawFragment = ""
+	if target.Path == "" {
+		target.Path = "/"
+	}
+	// Encode sorts the parameters by key
+	target.RawQuery = target.Query().Encode()
+	return target.String(), nil
+}
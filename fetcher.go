@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	cache "github.com/rohith2506/facedetect/cache"
+	events "github.com/rohith2506/facedetect/events"
+	fetch "github.com/rohith2506/facedetect/fetch"
+	models "github.com/rohith2506/facedetect/models"
+	utilities "github.com/rohith2506/facedetect/utilities"
+)
+
+// urlRecord remembers which version of an image url was fetched last, when, and the hash of its content
+type urlRecord struct {
+	fetch.Validators
+	ImageHash string
+	FetchedAt time.Time
+}
+
+// freshAt tells whether the record is recent enough to be used without contacting the upstream server
+func (record *urlRecord) freshAt(now time.Time, freshness time.Duration) bool {
+	return freshness > 0 && !record.FetchedAt.IsZero() && now.Sub(record.FetchedAt) < freshness
+}
+
+// fetchFailure is the error of detectURL when the image could not be fetched
+type fetchFailure struct {
+	err error
+}
+
+func (failure *fetchFailure) Error() string { return failure.err.Error() }
+
+func (failure *fetchFailure) Unwrap() error { return failure.err }
+
+// urlDigest identifies a normalized image url in the cache keys
+func urlDigest(imageURL string) string {
+	digest := sha256.Sum256([]byte(imageURL))
+	return hex.EncodeToString(digest[:])
+}
+
+// urlKey returns the cache key of the record of the image url with the given digest
+func (app *App) urlKey(digest string) string {
+	return strings.Join([]string{app.Namespace, urlSpace, digest}, ":")
+}
+
+// getURLRecord returns the record of the image url, or nil when it was never fetched
+func (app *App) getURLRecord(imageURL string) *urlRecord {
+	value, err := app.Cache.Get(app.urlKey(urlDigest(imageURL)))
+	if err != nil {
+		if err != cache.ErrNotFound {
+			log.Printf("Cache get failed: %v", err)
+		}
+		return nil
+	}
+	var record urlRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		log.Printf("Invalid url record: %v", err)
+		return nil
+	}
+	return &record
+}
+
+// saveURLRecord remembers the version of the image url which was just fetched
+func (app *App) saveURLRecord(imageURL string, record urlRecord) {
+	// without validators nor freshness window the record would never be used
+	if record.ETag == "" && record.LastModified == "" && app.URLFreshness == 0 {
+		return
+	}
+	digest := urlDigest(imageURL)
+	value, err := json.Marshal(record)
+	if err == nil {
+		err = app.Cache.Set(app.urlKey(digest), value, app.detectionTTL())
+	}
+	// erasing the image drops the record along with it
+	if err == nil {
+		err = app.Cache.Set(app.imageRefKey(record.ImageHash, urlRef, digest), []byte(digest), app.detectionTTL())
+	}
+	if err != nil {
+		log.Printf("Error in saving the url record: %v", err)
+	}
+}
+
+// detectURL runs the detection on the image behind rawImageURL. When the url was
+// fetched within URL_CACHE_FRESHNESS and its detection is still cached, the cached
+// detection is returned without contacting the upstream server. Past that window
+// the url is only revalidated, the image is downloaded again only if it changed.
+func (app *App) detectURL(ctx context.Context, rawImageURL string, imageExtension string, progress events.Reporter) (*RedisOutput, error) {
+	imageURL, err := fetch.NormalizeURL(rawImageURL)
+	if err != nil {
+		return nil, &fetchFailure{err: err}
+	}
+
+	var (
+		known  fetch.Validators
+		cached *RedisOutput
+	)
+	record := app.getURLRecord(imageURL)
+	if record != nil {
+		cached = app.getSignedDetection(app.detectionKey(record.ImageHash, renderOptions{
+			Style:     models.StyleAnnotated,
+			Extension: strings.ToLower(imageExtension),
+		}))
+		if cached != nil {
+			if record.freshAt(time.Now(), app.URLFreshness) {
+				app.stats.Hit()
+				progress.Report(events.StageCacheHit, gin.H{"url_cached": true})
+				return cached, nil
+			}
+			known = record.Validators
+		}
+	}
+
+	fetchedAt := time.Now()
+	result, err := app.Fetcher.FetchConditional(ctx, rawImageURL, known)
+	if err != nil {
+		return nil, &fetchFailure{err: err}
+	}
+	if result.NotModified {
+		app.stats.Hit()
+		progress.Report(events.StageCacheHit, gin.H{"not_modified": true})
+		app.saveURLRecord(imageURL, urlRecord{Validators: result.Validators, ImageHash: record.ImageHash, FetchedAt: fetchedAt})
+		return cached, nil
+	}
+
+	output, err := app.detectFaces(result.Data, imageExtension, progress)
+	if err != nil {
+		return nil, err
+	}
+	app.saveURLRecord(imageURL, urlRecord{
+		Validators: result.Validators,
+		ImageHash:  utilities.GetBytesHash(result.Data),
+		FetchedAt:  fetchedAt,
+	})
+	return output, nil
+}
+
+// fetchFailureResponse returns the http status and the body answering a failed fetch.
+// Upstream failures carry the status and the content type of the upstream server.
+func fetchFailureResponse(err error) (int, gin.H) {
+	response := gin.H{"image fetch failed": err.Error()}
+	var upstreamErr *fetch.UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return http.StatusBadRequest, response
+	}
+	response["upstream_status"] = upstreamErr.StatusCode
+	response["upstream_content_type"] = upstreamErr.ContentType
+	if upstreamErr.StatusCode >= http.StatusInternalServerError {
+		return http.StatusBadGateway, response
+	}
+	return http.StatusBadRequest, response
+}
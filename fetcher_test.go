@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	fetch "github.com/rohith2506/facedetect/fetch"
+)
+
+func TestFetchFailureResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream is down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	fetcher := fetch.NewFetcher(fetch.Config{Allow: fetch.MustParseCIDRs("127.0.0.0/8")})
+	_, err := fetcher.Fetch(context.Background(), server.URL+"/elon.jpg")
+	status, response := fetchFailureResponse(err)
+	if status != http.StatusBadGateway || response["upstream_status"] != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected response %d %v", status, response)
+	}
+
+	_, err = fetch.NewFetcher(fetch.Config{}).Fetch(context.Background(), server.URL+"/elon.jpg")
+	status, response = fetchFailureResponse(err)
+	if status != http.StatusBadRequest || response["upstream_status"] != nil {
+		t.Fatalf("unexpected response %d %v", status, response)
+	}
+}
+
+func TestURLRecordFreshness(t *testing.T) {
+	now := time.Now()
+	record := urlRecord{ImageHash: testImageHash, FetchedAt: now.Add(-30 * time.Minute)}
+	if !record.freshAt(now, time.Hour) {
+		t.Fatal("expected the record to be fresh within the window")
+	}
+	if record.freshAt(now, 10*time.Minute) || record.freshAt(now, 0) {
+		t.Fatal("expected the record to be stale past the window or without one")
+	}
+	if (&urlRecord{ImageHash: testImageHash}).freshAt(now, time.Hour) {
+		t.Fatal("expected a record without fetch time to be stale")
+	}
+}
@@ -6,11 +6,14 @@ require (
 	github.com/aws/aws-sdk-go v1.32.3
 	github.com/esimov/pigo v1.4.2
 	github.com/fogleman/gg v1.3.0
+	github.com/gin-contrib/sse v0.1.0
 	github.com/gin-gonic/contrib v0.0.0-20191209060500-d6e26eeaa607
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-playground/assert/v2 v2.0.1
 	github.com/go-redis/redis/v7 v7.2.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	golang.org/x/net v0.0.0-20200602114024-627f9648deb9 // indirect
 	golang.org/x/text v0.3.3 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	events "github.com/rohith2506/facedetect/events"
+	fetch "github.com/rohith2506/facedetect/fetch"
+	jobs "github.com/rohith2506/facedetect/jobs"
+	redis "github.com/rohith2506/facedetect/redis"
+	utilities "github.com/rohith2506/facedetect/utilities"
+	webhooks "github.com/rohith2506/facedetect/webhooks"
+)
+
+const (
+	jobWorkers       = 4
+	jobQueueSize     = 100
+	jobTTL           = 24 * time.Hour
+	callbackAttempts = 5
+	callbackBackoff  = 2 * time.Second
+	callbackTimeout  = 10 * time.Second
+)
+
+// setupJobs creates and starts the job pool. The job state and the callback
+// deliveries live in redis, or in memory when conn is nil. The callbacks go
+// through the address checks of the image fetches.
+func (app *App) setupJobs(conn *redis.Connection, network fetch.Config) {
+	var (
+		store       jobs.Store
+		deliveryLog webhooks.Log
+	)
+	if conn == nil {
+		store = jobs.NewMemoryStore(jobTTL)
+		deliveryLog = webhooks.NewMemoryLog()
+	} else {
+		store = jobs.NewRedisStore(conn, jobTTL)
+		deliveryLog = webhooks.NewRedisLog(conn, jobTTL)
+	}
+
+	secret := app.Config.WebhookSecret
+	if secret == "" {
+		log.Printf("WEBHOOK_SECRET is not set, job callbacks are disabled")
+	}
+	network.Timeout = callbackTimeout
+	client := fetch.NewClient(network)
+	notifier := webhooks.NewNotifier([]byte(secret), deliveryLog, client, callbackAttempts, callbackBackoff)
+
+	pool := jobs.NewPool(store, jobWorkers, jobQueueSize)
+	pool.OnFinish(func(job jobs.Job) {
+		if job.CallbackURL != "" {
+			go notifier.Notify(job.ID, job.CallbackURL, job)
+		}
+	})
+	pool.Start()
+	app.Jobs, app.Notifier = pool, notifier
+}
+
+// validateCallbackURL makes sure the callback is an absolute http(s) url
+func validateCallbackURL(rawCallbackURL string) error {
+	callbackURL, err := url.ParseRequestURI(rawCallbackURL)
+	if err != nil {
+		return err
+	}
+	if (callbackURL.Scheme != "http" && callbackURL.Scheme != "https") || callbackURL.Host == "" {
+		return errors.New("callback url must be an absolute http or https url")
+	}
+	return nil
+}
+
+// detectionTask builds the job task running the face detection on the
+// uploaded image or, when there is no upload, on the image behind rawImageURL
+func (app *App) detectionTask(imageData []byte, rawImageURL string, imageExtension string) jobs.Task {
+	return func(job *jobs.Job) (interface{}, error) {
+		start := time.Now()
+		progress := app.Progress.Reporter(job.ID)
+		progress.Report(events.StageReceived, gin.H{"job_id": job.ID})
+
+		output, err := app.runDetectionTask(imageData, rawImageURL, imageExtension, progress)
+		if err != nil {
+			progress.Report(events.StageFailed, gin.H{"error": err.Error()})
+			return nil, err
+		}
+		// erasing the image drops the job along with it
+		if imageHash := imageHashFromKey(output.ImageKey); imageHash != "" {
+			if err := app.Cache.Set(app.imageRefKey(imageHash, jobRef, job.ID), []byte(job.ID), jobTTL); err != nil {
+				log.Printf("Error in referencing job %s: %v", job.ID, err)
+			}
+		}
+		result := gin.H{
+			"landmarks": output.Landmarks,
+			"image_url": output.ImageURL,
+			"time_took": time.Since(start).Milliseconds(),
+		}
+		progress.Report(events.StageDone, result)
+		return result, nil
+	}
+}
+
+func (app *App) runDetectionTask(imageData []byte, rawImageURL string, imageExtension string, progress events.Reporter) (*RedisOutput, error) {
+	if imageData == nil {
+		return app.detectURL(context.Background(), rawImageURL, imageExtension, progress)
+	}
+	return app.detectFaces(imageData, imageExtension, progress)
+}
+
+// JobSubmitHandler endpoint queues a face detection for either an uploaded
+// file or an image url and returns the job id straight away. When a
+// callback_url is given, the finished job is posted to it.
+func (app *App) JobSubmitHandler(c *gin.Context) {
+	var (
+		task           jobs.Task
+		imageExtension string
+	)
+
+	callbackURL := c.PostForm("callback_url")
+	if callbackURL != "" {
+		if !app.Notifier.Enabled() {
+			c.JSON(http.StatusBadRequest, gin.H{"invalid callback url": "callbacks are disabled, set WEBHOOK_SECRET"})
+			return
+		}
+		if err := validateCallbackURL(callbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"invalid callback url": err.Error()})
+			return
+		}
+	}
+
+	if file, err := c.FormFile("file"); err == nil {
+		imageExtension = filepath.Ext(file.Filename)
+		if _, found := utilities.Find(availableExtensions, imageExtension); !found {
+			c.JSON(http.StatusBadRequest, gin.H{"invalid image extension": "possible url extensions are [jpg, jpeg, png]. This limitation will be fixed soon."})
+			return
+		}
+
+		// The upload is gone once the request is over, so read it right now
+		imageData, err := readImage(file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"invalid input file": err.Error()})
+			return
+		}
+		task = app.detectionTask(imageData, "", imageExtension)
+	} else {
+		rawImageURL := c.PostForm("image_url")
+		imageURL, err := url.ParseRequestURI(rawImageURL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"invalid url": err})
+			return
+		}
+		imageExtension = filepath.Ext(imageURL.Path)
+		if _, found := utilities.Find(availableExtensions, imageExtension); !found {
+			c.JSON(http.StatusBadRequest, gin.H{"invalid image extension": "possible extensions are [jpg, jpeg, png]. This limitation will be fixed soon."})
+			return
+		}
+		task = app.detectionTask(nil, rawImageURL, imageExtension)
+	}
+
+	job, err := app.Jobs.Submit(task, callbackURL)
+	if err == jobs.ErrQueueFull {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"job submission failed": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"job submission failed": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status":     job.Status,
+		"status_url": "/v1/jobs/" + job.ID,
+	})
+}
+
+// JobStatusHandler endpoint returns the status and, once finished, the result of a job
+func (app *App) JobStatusHandler(c *gin.Context) {
+	job, err := app.Jobs.Get(c.Param("id"))
+	if err == jobs.ErrJobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"job not found": c.Param("id")})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"job lookup failed": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// JobDeliveriesHandler endpoint lists the callback delivery attempts of a job
+func (app *App) JobDeliveriesHandler(c *gin.Context) {
+	if _, err := app.Jobs.Get(c.Param("id")); err == jobs.ErrJobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"job not found": c.Param("id")})
+		return
+	}
+
+	deliveries, err := app.Notifier.Deliveries(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"delivery lookup failed": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
@@ -0,0 +1,262 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	redis "github.com/rohith2506/facedetect/redis"
+)
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	keyPrefix = "job:"
+	idBytes   = 16
+)
+
+// Errors returned by the job pool and the stores
+var (
+	ErrQueueFull   = errors.New("job queue is full")
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// Job ...
+type Job struct {
+	ID          string      `json:"id"`
+	Status      string      `json:"status"`
+	Result      interface{} `json:"result,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	CallbackURL string      `json:"callback_url,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Task is the unit of work executed by a worker. The returned value is stored as the job result.
+type Task func(job *Job) (interface{}, error)
+
+// Store persists the state of the jobs
+type Store interface {
+	Save(job *Job) error
+	Get(id string) (*Job, error)
+	// Delete forgets the job, it reports whether the job existed
+	Delete(id string) (bool, error)
+}
+
+// RedisStore keeps the jobs in redis, expiring them after ttl
+type RedisStore struct {
+	conn *redis.Connection
+	ttl  time.Duration
+}
+
+// NewRedisStore ...
+func NewRedisStore(conn *redis.Connection, ttl time.Duration) *RedisStore {
+	return &RedisStore{conn: conn, ttl: ttl}
+}
+
+// Save ...
+func (store *RedisStore) Save(job *Job) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return store.conn.SetKeyWithExpiry(keyPrefix+job.ID, value, store.ttl)
+}
+
+// Get ...
+func (store *RedisStore) Get(id string) (*Job, error) {
+	value, err := store.conn.GetKey(keyPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, ErrJobNotFound
+	}
+	var job Job
+	if err := json.Unmarshal([]byte(value), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Delete ...
+func (store *RedisStore) Delete(id string) (bool, error) {
+	removed, err := store.conn.DeleteKeys(keyPrefix + id)
+	return removed > 0, err
+}
+
+// MemoryStore keeps the jobs in process. It is used when redis is not reachable.
+type MemoryStore struct {
+	mutex sync.RWMutex
+	jobs  map[string]Job
+	ttl   time.Duration
+}
+
+// NewMemoryStore ...
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job), ttl: ttl}
+}
+
+// Save ...
+func (store *MemoryStore) Save(job *Job) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Drop the expired jobs so that the map does not grow forever
+	now := time.Now()
+	for id, existing := range store.jobs {
+		if store.ttl > 0 && now.Sub(existing.UpdatedAt) > store.ttl {
+			delete(store.jobs, id)
+		}
+	}
+	store.jobs[job.ID] = *job
+	return nil
+}
+
+// Get ...
+func (store *MemoryStore) Get(id string) (*Job, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	job, found := store.jobs[id]
+	if !found || (store.ttl > 0 && time.Since(job.UpdatedAt) > store.ttl) {
+		return nil, ErrJobNotFound
+	}
+	return &job, nil
+}
+
+// Delete ...
+func (store *MemoryStore) Delete(id string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, found := store.jobs[id]
+	delete(store.jobs, id)
+	return found, nil
+}
+
+type queuedJob struct {
+	job  *Job
+	task Task
+}
+
+// Pool executes the submitted jobs using a fixed number of workers
+type Pool struct {
+	store    Store
+	queue    chan queuedJob
+	workers  int
+	once     sync.Once
+	onFinish func(job Job)
+}
+
+// NewPool ...
+func NewPool(store Store, workers int, queueSize int) *Pool {
+	return &Pool{
+		store:   store,
+		queue:   make(chan queuedJob, queueSize),
+		workers: workers,
+	}
+}
+
+// Start launches the workers. Calling it more than once has no effect.
+func (pool *Pool) Start() {
+	pool.once.Do(func() {
+		for i := 0; i < pool.workers; i++ {
+			go pool.work()
+		}
+	})
+}
+
+// OnFinish registers a function called with the final state of every job run by the workers
+func (pool *Pool) OnFinish(onFinish func(job Job)) {
+	pool.onFinish = onFinish
+}
+
+// Submit queues the task and returns a snapshot of the created job straight away. The
+// callbackURL is kept on the job for the OnFinish function, it may be empty.
+func (pool *Pool) Submit(task Task, callbackURL string) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	job := &Job{
+		ID:          id,
+		Status:      StatusQueued,
+		CallbackURL: callbackURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := pool.store.Save(job); err != nil {
+		return nil, err
+	}
+
+	// the worker updates its own copy, so the returned job stays a snapshot of the queued one
+	queued := *job
+	select {
+	case pool.queue <- queuedJob{job: &queued, task: task}:
+		return job, nil
+	default:
+		pool.finish(job, nil, ErrQueueFull)
+		return nil, ErrQueueFull
+	}
+}
+
+// Get returns the current state of the job
+func (pool *Pool) Get(id string) (*Job, error) {
+	return pool.store.Get(id)
+}
+
+// Delete forgets the job and its result
+func (pool *Pool) Delete(id string) (bool, error) {
+	return pool.store.Delete(id)
+}
+
+func (pool *Pool) work() {
+	for queued := range pool.queue {
+		job := queued.job
+		job.Status = StatusRunning
+		job.UpdatedAt = time.Now().UTC()
+		if err := pool.store.Save(job); err != nil {
+			log.Printf("Error in saving job %s: %v", job.ID, err)
+		}
+
+		result, err := queued.task(job)
+		pool.finish(job, result, err)
+		if pool.onFinish != nil {
+			pool.onFinish(*job)
+		}
+	}
+}
+
+func (pool *Pool) finish(job *Job, result interface{}, err error) {
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = StatusDone
+		job.Result = result
+	}
+	job.UpdatedAt = time.Now().UTC()
+	if err := pool.store.Save(job); err != nil {
+		log.Printf("Error in saving job %s: %v", job.ID, err)
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
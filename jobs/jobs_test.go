@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitForJob(t *testing.T, pool *Pool, id string) *Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := pool.Get(id)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if job.Status == StatusDone || job.Status == StatusFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return nil
+}
+
+func TestPoolRunsJobs(t *testing.T) {
+	pool := NewPool(NewMemoryStore(time.Hour), 2, 10)
+	pool.Start()
+
+	job, err := pool.Submit(func(job *Job) (interface{}, error) {
+		return "faces", nil
+	}, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if job = waitForJob(t, pool, job.ID); job.Status != StatusDone || job.Result != "faces" {
+		t.Fatalf("unexpected job state: %+v", job)
+	}
+
+	job, _ = pool.Submit(func(job *Job) (interface{}, error) {
+		return nil, errors.New("detector down")
+	}, "")
+	if job = waitForJob(t, pool, job.ID); job.Status != StatusFailed || job.Error != "detector down" {
+		t.Fatalf("unexpected job state: %+v", job)
+	}
+}
+
+func TestSubmitReturnsSnapshot(t *testing.T) {
+	pool := NewPool(NewMemoryStore(time.Hour), 1, 1)
+	pool.Start()
+
+	running := make(chan struct{})
+	job, err := pool.Submit(func(job *Job) (interface{}, error) {
+		close(running)
+		return nil, nil
+	}, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	<-running
+	// the worker never touches the job handed back to the caller
+	if job.Status != StatusQueued {
+		t.Fatalf("expected the submitted job to stay queued, got %s", job.Status)
+	}
+	waitForJob(t, pool, job.ID)
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	// The pool is not started, so the queue fills up
+	pool := NewPool(NewMemoryStore(time.Hour), 1, 1)
+	task := func(job *Job) (interface{}, error) { return nil, nil }
+	if _, err := pool.Submit(task, ""); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, err := pool.Submit(task, ""); err != ErrQueueFull {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+}
+
+func TestMemoryStoreNotFound(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	if _, err := store.Get("missing"); err != ErrJobNotFound {
+		t.Fatalf("expected %v, got %v", ErrJobNotFound, err)
+	}
+}
+
+func TestPoolDelete(t *testing.T) {
+	pool := NewPool(NewMemoryStore(time.Hour), 1, 1)
+	pool.Start()
+	job, err := pool.Submit(func(job *Job) (interface{}, error) { return "result", nil }, "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	waitForJob(t, pool, job.ID)
+
+	if deleted, err := pool.Delete(job.ID); err != nil || !deleted {
+		t.Fatalf("expected the job to be deleted, got %v: %v", deleted, err)
+	}
+	if _, err := pool.Get(job.ID); err != ErrJobNotFound {
+		t.Fatalf("expected %v, got %v", ErrJobNotFound, err)
+	}
+	if deleted, err := pool.Delete(job.ID); err != nil || deleted {
+		t.Fatalf("expected nothing to delete, got %v: %v", deleted, err)
+	}
+}
+
+func TestPoolOnFinish(t *testing.T) {
+	pool := NewPool(NewMemoryStore(time.Hour), 1, 1)
+	finished := make(chan Job, 1)
+	pool.OnFinish(func(job Job) { finished <- job })
+	pool.Start()
+
+	pool.Submit(func(job *Job) (interface{}, error) { return nil, nil }, "http://localhost/callback")
+	select {
+	case job := <-finished:
+		if job.Status != StatusDone || job.CallbackURL != "http://localhost/callback" {
+			t.Fatalf("unexpected job state: %+v", job)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job did not finish")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	fetch "github.com/rohith2506/facedetect/fetch"
+)
+
+func performJobRequest(r http.Handler, callbackURL string) *httptest.ResponseRecorder {
+	params := url.Values{}
+	params.Add("image_url", "http://127.0.0.1/elon.jpg")
+	params.Add("callback_url", callbackURL)
+	req, _ := http.NewRequest("POST", "/v1/jobs", strings.NewReader(params.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestJobCallbackRequiresSecret(t *testing.T) {
+	app := newTestApp(t)
+	app.setupJobs(nil, fetch.Config{})
+	w := performJobRequest(SetupRouter(app), "http://10.0.0.1/callback")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	app.Config.WebhookSecret = "secret"
+	app.setupJobs(nil, fetch.Config{})
+	w = performJobRequest(SetupRouter(app), "http://10.0.0.1/callback")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
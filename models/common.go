@@ -7,32 +7,35 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"log"
 	"math"
-	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	pigo "github.com/esimov/pigo/core"
 	"github.com/fogleman/gg"
 	"github.com/nfnt/resize"
-	"github.com/rohith2506/facedetect/s3"
-)
-
-var (
-	dc  *gg.Context
-	dst io.Writer
-	fn  *os.File
+	events "github.com/rohith2506/facedetect/events"
+	storage "github.com/rohith2506/facedetect/storage"
 )
 
 // Model Constants
 const (
 	PicoModel    = 1
 	MTCNNModel   = 2
-	outputDir    = "/tmp/images/out/"
+	MTCNNName    = "mtcnn"
+	MTCNNVersion = "1" // part of the cache keys, bump it whenever the detections or the rendering change
 	adjustedCols = 300
 	adjustedRows = 400
-	environment  = "default"
-	bucket       = "facedetection25"
+	// rendered images are stored under the hash of their source, they never change
+	renderedCacheControl = "public, max-age=31536000, immutable"
+)
+
+// Render styles
+const (
+	StyleAnnotated  = "annotated"
+	StyleAnonymized = "anonymized"
 )
 
 // Coord ...
@@ -56,67 +59,79 @@ type Detection struct {
 	RightEye  Coord     `json:"right_eye,omitempty"`
 	Mouth     []Coord   `json:"mouth,omitempty"`
 	Nose      Coord     `json:"nose,omitempty"`
+	TrackID   int       `json:"track_id,omitempty"`
 }
 
-// Let's create the output file
-func createOutputFile(imagePath string) {
-	fn, err := os.OpenFile(imagePath, os.O_CREATE|os.O_WRONLY, 0755)
-	if err != nil {
-		log.Fatalf("Unable to open output file: %v", err)
+// Scale returns the detection moved to an image resized by the given factors
+func (detection Detection) Scale(scaleX float64, scaleY float64) Detection {
+	scale := func(coord Coord) Coord {
+		return Coord{Row: int(math.Round(float64(coord.Row) * scaleX)), Col: int(math.Round(float64(coord.Col) * scaleY))}
+	}
+	scaled := detection
+	scaled.FaceCoord = RectCoord{
+		Row:    int(math.Round(float64(detection.FaceCoord.Row) * scaleX)),
+		Col:    int(math.Round(float64(detection.FaceCoord.Col) * scaleY)),
+		Width:  int(math.Round(float64(detection.FaceCoord.Width) * scaleX)),
+		Height: int(math.Round(float64(detection.FaceCoord.Height) * scaleY)),
+	}
+	scaled.LeftEye = scale(detection.LeftEye)
+	scaled.RightEye = scale(detection.RightEye)
+	scaled.Nose = scale(detection.Nose)
+	scaled.Mouth = make([]Coord, len(detection.Mouth))
+	for i, mouth := range detection.Mouth {
+		scaled.Mouth[i] = scale(mouth)
+	}
+	return scaled
+}
+
+// ContentType returns the mime type of the images encoded for ext
+func ContentType(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".png":
+		return "image/png"
+	default:
+		return "image/jpeg"
 	}
-	dst = fn
 }
 
 // encode the image
-func encodeImage(dst io.Writer) error {
+func encodeImage(dst io.Writer, img image.Image, ext string) error {
 	var err error
-	img := dc.Image()
 	newImage := resize.Resize(adjustedRows, adjustedCols, img, resize.Lanczos3)
 
-	switch dst.(type) {
-	case *os.File:
-		ext := filepath.Ext(dst.(*os.File).Name())
-		switch ext {
-		case "", ".jpg", ".jpeg":
-			err = jpeg.Encode(dst, newImage, &jpeg.Options{Quality: 100})
-		case ".png":
-			err = png.Encode(dst, newImage)
-		default:
-			err = errors.New("unsupported image format")
-		}
-	default:
+	switch strings.ToLower(ext) {
+	case "", ".jpg", ".jpeg":
 		err = jpeg.Encode(dst, newImage, &jpeg.Options{Quality: 100})
+	case ".png":
+		err = png.Encode(dst, newImage)
+	default:
+		err = errors.New("unsupported image format")
 	}
 	return err
 }
 
-func drawImages(imagePath string, faces []Detection) {
-	reader, err := os.Open(imagePath)
-	if err != nil {
-		log.Fatalf("Error in reading the image file: %v", err)
-	}
-
-	// Decode the image
-	img, _, err := image.Decode(reader)
-	if err != nil {
-		log.Fatalf("Error in decoding the image: %v", err)
-	}
-
+// RenderImage draws the faces on the decoded image using the given style and
+// writes the resized result to dst, encoded according to ext.
+func RenderImage(img image.Image, faces []Detection, style string, dst io.Writer, ext string) error {
 	src := pigo.ImgToNRGBA(img)
 	cols, rows := src.Bounds().Max.X, src.Bounds().Max.Y
 
-	dc = gg.NewContext(cols, rows)
+	dc := gg.NewContext(cols, rows)
 	dc.DrawImage(src, 0, 0)
 
-	drawFaces(faces)
-
-	if err := encodeImage(dst); err != nil {
-		log.Fatalf("Error encoding the output image: %v", err)
+	switch style {
+	case "", StyleAnnotated:
+		drawFaces(dc, faces)
+	case StyleAnonymized:
+		pixelateFaces(dc, src, faces)
+	default:
+		return errors.New("unsupported render style")
 	}
-	defer fn.Close()
+
+	return encodeImage(dst, dc.Image(), ext)
 }
 
-func drawFaces(faces []Detection) {
+func drawFaces(dc *gg.Context, faces []Detection) {
 	for _, face := range faces {
 		// Draw the face
 		dc.DrawRectangle(float64(face.FaceCoord.Row), float64(face.FaceCoord.Col),
@@ -157,30 +172,86 @@ func drawFaces(faces []Detection) {
 	}
 }
 
-// RunFaceDetection ....
-func RunFaceDetection(outputImageName string, imagePath string) []Detection {
-	// Find the facial landmarks
-	result := DetectMTCNN(imagePath)
+// pixelateFaces replaces every face region with coarse blocks so that the
+// person can no longer be recognised.
+func pixelateFaces(dc *gg.Context, src *image.NRGBA, faces []Detection) {
+	bounds := src.Bounds()
+	for _, face := range faces {
+		rect := image.Rect(face.FaceCoord.Row, face.FaceCoord.Col,
+			face.FaceCoord.Row+face.FaceCoord.Width, face.FaceCoord.Col+face.FaceCoord.Height).Intersect(bounds)
+		if rect.Empty() {
+			continue
+		}
 
-	// create an output image
-	outputImageLoc := outputDir + outputImageName
-	createOutputFile(outputImageLoc)
+		blockSize := int(math.Max(4, float64(rect.Dx()/8)))
+		for y := rect.Min.Y; y < rect.Max.Y; y += blockSize {
+			for x := rect.Min.X; x < rect.Max.X; x += blockSize {
+				block := image.Rect(x, y, x+blockSize, y+blockSize).Intersect(rect)
+				dc.DrawRectangle(float64(block.Min.X), float64(block.Min.Y), float64(block.Dx()), float64(block.Dy()))
+				dc.SetColor(averageColor(src, block))
+				dc.Fill()
+			}
+		}
+	}
+}
 
-	// Draw the final image
-	drawImages(imagePath, result)
+// averageColor returns the mean colour of the pixels inside rect
+func averageColor(src *image.NRGBA, rect image.Rectangle) color.Color {
+	var r, g, b, count uint64
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			pixel := src.NRGBAAt(x, y)
+			r += uint64(pixel.R)
+			g += uint64(pixel.G)
+			b += uint64(pixel.B)
+			count++
+		}
+	}
+	if count == 0 {
+		return color.Black
+	}
+	return color.NRGBA{R: uint8(r / count), G: uint8(g / count), B: uint8(b / count), A: 255}
+}
 
-	// Upload it to s3
-	connection := s3.GetAwsSession(environment)
-	err := connection.UploadFile(outputImageLoc, outputImageName, bucket)
+// RunFaceDetection detects the faces on the encoded image, draws them on the
+// decoded img and streams the rendered image straight to the store, along
+// with the hash of the source image, the model and the number of faces
+func RunFaceDetection(detector Detector, sourceHash string, outputImageName string, imageData []byte, img image.Image, store storage.ImageStore, progress events.Reporter) ([]Detection, error) {
+	// Find the facial landmarks
+	result, err := detector.Detect(imageData)
 	if err != nil {
-		log.Fatalf("Error in uploading file to aws: %v", err)
+		return nil, err
 	}
+	progress.Report(events.StageDetected, result)
 
-	// delete the output image from local
-	err = os.Remove(outputImageLoc)
-	if err != nil {
-		log.Fatalf("Error in deleting the output image: %v", err)
+	if err := StoreRendering(sourceHash, outputImageName, img, result, store, progress); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	return result
+// StoreRendering draws the faces on img and streams the rendered image to the store
+func StoreRendering(sourceHash string, outputImageName string, img image.Image, faces []Detection, store storage.ImageStore, progress events.Reporter) error {
+	// Draw the final image while it is uploaded to the image store
+	reader, writer := io.Pipe()
+	go func() {
+		err := RenderImage(img, faces, StyleAnnotated, writer, filepath.Ext(outputImageName))
+		if err == nil {
+			progress.Report(events.StageRendered, nil)
+		}
+		writer.CloseWithError(err)
+	}()
+	err := store.Put(outputImageName, reader, storage.ImageInfo{
+		ContentType:  ContentType(filepath.Ext(outputImageName)),
+		CacheControl: renderedCacheControl,
+		Metadata: map[string]string{
+			storage.MetaSourceHash: sourceHash,
+			storage.MetaModel:      MTCNNName,
+			storage.MetaFaceCount:  strconv.Itoa(len(faces)),
+			storage.MetaCreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	// unblock the renderer when the upload stopped early
+	reader.Close()
+	return err
 }
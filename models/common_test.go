@@ -0,0 +1,24 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectionScale(t *testing.T) {
+	detection := Detection{
+		FaceCoord: RectCoord{Row: 10, Col: 20, Width: 30, Height: 40},
+		LeftEye:   Coord{Row: 15, Col: 25},
+		Mouth:     []Coord{{Row: 12, Col: 50}},
+		TrackID:   3,
+	}
+	expected := Detection{
+		FaceCoord: RectCoord{Row: 20, Col: 10, Width: 60, Height: 20},
+		LeftEye:   Coord{Row: 30, Col: 13},
+		Mouth:     []Coord{{Row: 24, Col: 25}},
+		TrackID:   3,
+	}
+	if scaled := detection.Scale(2, 0.5); !reflect.DeepEqual(scaled, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, scaled)
+	}
+}
@@ -1,9 +1,13 @@
 package models
 
 import (
+	"encoding/binary"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
 	"net"
+	"sync"
+	"time"
 )
 
 // Host and Port constants ...
@@ -11,15 +15,29 @@ const (
 	connectionType = "tcp"
 	Host           = "localhost"
 	Port           = "3333"
-	MaxBufSize     = 20000 // I don't think it will be more than 2k bytes
+	MaxBufSize     = 1 << 20 // answers are a few kilobytes, even with many faces
+	headerSize     = 4
+	// DefaultTimeout bounds a detection
+	DefaultTimeout = 30 * time.Second
 )
 
-var conn net.Conn
+// Detector finds the faces on an encoded image
+type Detector interface {
+	Detect(imageData []byte) ([]Detection, error)
+}
 
-func createNewConnection() {
-	if conn == nil {
-		conn, _ = net.Dial(connectionType, Host+":"+Port)
-	}
+// MTCNN is the client of the python wrapper running mtcnn. The wrapper answers
+// one request at a time on a connection, so the requests are serialized.
+type MTCNN struct {
+	addr    string
+	timeout time.Duration
+	mutex   sync.Mutex
+	conn    net.Conn
+}
+
+// NewMTCNN creates a client of the python wrapper listening on addr, it connects on the first detection
+func NewMTCNN(addr string, timeout time.Duration) *MTCNN {
+	return &MTCNN{addr: addr, timeout: timeout}
 }
 
 func convertInterface(input []interface{}) []int {
@@ -30,23 +48,57 @@ func convertInterface(input []interface{}) []int {
 	return output
 }
 
-// DetectMTCNN ...
-func DetectMTCNN(imagePath string) []Detection {
-	createNewConnection()
-	if conn == nil {
-		log.Fatalf("Connection to python wrapper not established well")
+// exchange sends a length prefixed request and reads the length prefixed answer
+func exchange(conn net.Conn, request []byte, timeout time.Duration) ([]byte, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header, uint32(len(request)))
+	if _, err := conn.Write(append(header, request...)); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > MaxBufSize {
+		return nil, fmt.Errorf("detector answer of %d bytes is too large", size)
+	}
+	output := make([]byte, size)
+	if _, err := io.ReadFull(conn, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Detect sends the encoded image to the python wrapper and returns the detected faces
+func (detector *MTCNN) Detect(imageData []byte) ([]Detection, error) {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	if detector.conn == nil {
+		conn, err := net.Dial(connectionType, detector.addr)
+		if err != nil {
+			return nil, fmt.Errorf("connection to python wrapper not established: %v", err)
+		}
+		detector.conn = conn
 	}
 
-	// Fire the request and get the result in terms of bytes
-	conn.Write([]byte(imagePath))
-	output := make([]byte, MaxBufSize)
-	receivedPayloadSize, _ := conn.Read(output)
-	output = output[:receivedPayloadSize]
+	output, err := exchange(detector.conn, imageData, detector.timeout)
+	if err != nil {
+		// The connection is in an unknown state, start over on the next request
+		detector.conn.Close()
+		detector.conn = nil
+		return nil, err
+	}
 
 	var results []map[string]interface{}
 	var facialLandMarks []Detection
 
-	json.Unmarshal(output, &results)
+	if err := json.Unmarshal(output, &results); err != nil {
+		return nil, fmt.Errorf("detector failed: %s", output)
+	}
 
 	for _, result := range results {
 		face := convertInterface(result["box"].([]interface{}))
@@ -117,5 +169,5 @@ func DetectMTCNN(imagePath string) []Detection {
 		})
 	}
 
-	return facialLandMarks
+	return facialLandMarks, nil
 }
@@ -0,0 +1,28 @@
+//go:build integration
+// +build integration
+
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMTCNNDetect(t *testing.T) {
+	imageData, err := ioutil.ReadFile("../test_images/elon.jpg")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	faces, err := NewMTCNN(net.JoinHostPort(Host, Port), DefaultTimeout).Detect(imageData)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	result, err := json.Marshal(faces)
+	wanted := "{\"y\":909,\"x\":298,\"width\":705,\"height\":987}"
+	if err != nil || strings.Index(string(result), wanted) < 0 {
+		t.Fail()
+	}
+}
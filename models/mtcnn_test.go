@@ -1,16 +1,57 @@
 package models
 
 import (
-	"encoding/json"
-	"strings"
+	"bytes"
 	"testing"
+	"time"
+
+	mtcnntest "github.com/rohith2506/facedetect/models/mtcnntest"
 )
 
-func TestDetectMTCNN(t *testing.T) {
-	imagePath := "../test_images/elon.jpg"
-	result, err := json.Marshal(DetectMTCNN(imagePath))
-	wanted := "{\"y\":909,\"x\":298,\"width\":705,\"height\":987}"
-	if err != nil || strings.Index(string(result), wanted) < 0 {
-		t.Fail()
+func TestMTCNNDetectFake(t *testing.T) {
+	server, err := mtcnntest.NewServer(mtcnntest.Elon)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer server.Close()
+
+	detector := NewMTCNN(server.Addr(), time.Second)
+	imageData := []byte("not really a jpeg")
+	for i := 0; i < 2; i++ {
+		faces, err := detector.Detect(imageData)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if len(faces) != 1 || faces[0].FaceCoord != (RectCoord{Row: 909, Col: 298, Width: 705, Height: 987}) {
+			t.Fatalf("unexpected faces %+v", faces)
+		}
+		if faces[0].Nose != (Coord{Row: 1268, Col: 864}) || len(faces[0].Mouth) != 2 {
+			t.Fatalf("unexpected landmarks %+v", faces[0])
+		}
+	}
+	if requests := server.Requests(); len(requests) != 2 || !bytes.Equal(requests[0], imageData) {
+		t.Fatalf("expected the image to be sent twice, got %d requests", len(requests))
+	}
+}
+
+func TestMTCNNDetectFailure(t *testing.T) {
+	server, err := mtcnntest.NewServer(`{"error": "cannot decode the image"}`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	addr := server.Addr()
+	server.Close()
+
+	if _, err := NewMTCNN(addr, time.Second).Detect([]byte("image")); err == nil {
+		t.Fatalf("expected an error without a detector")
+	}
+
+	server, err = mtcnntest.NewServer(`{"error": "cannot decode the image"}`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer server.Close()
+	if _, err := NewMTCNN(server.Addr(), time.Second).Detect([]byte("image")); err == nil {
+		t.Fatalf("expected an error for an unexpected answer")
 	}
 }
@@ -0,0 +1,100 @@
+package mtcnntest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// Elon is the answer of the python wrapper for test_images/elon.jpg
+const Elon = `[{"box": [909, 298, 705, 987], "confidence": 0.9999, "keypoints": {` +
+	`"left_eye": [1101, 684], "right_eye": [1418, 671], "nose": [1268, 864], ` +
+	`"mouth_left": [1137, 1051], "mouth_right": [1402, 1040]}}]`
+
+const headerSize = 4
+
+// Server stands in for the python wrapper: it answers every length prefixed
+// request with the same canned json.
+type Server struct {
+	listener net.Listener
+	answer   []byte
+	mutex    sync.Mutex
+	requests [][]byte
+	conns    map[net.Conn]struct{}
+}
+
+// NewServer starts a server answering with answer on a random local port
+func NewServer(answer string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{listener: listener, answer: []byte(answer), conns: make(map[net.Conn]struct{})}
+	go server.serve()
+	return server, nil
+}
+
+// Addr returns the address the server listens on
+func (server *Server) Addr() string {
+	return server.listener.Addr().String()
+}
+
+// Requests returns the images received so far
+func (server *Server) Requests() [][]byte {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([][]byte(nil), server.requests...)
+}
+
+// Close stops the server and drops the connections
+func (server *Server) Close() error {
+	err := server.listener.Close()
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for conn := range server.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func (server *Server) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.mutex.Lock()
+		server.conns[conn] = struct{}{}
+		server.mutex.Unlock()
+		go server.handle(conn)
+	}
+}
+
+func (server *Server) handle(conn net.Conn) {
+	defer func() {
+		server.mutex.Lock()
+		delete(server.conns, conn)
+		server.mutex.Unlock()
+		conn.Close()
+	}()
+
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		server.mutex.Lock()
+		server.requests = append(server.requests, request)
+		server.mutex.Unlock()
+
+		binary.BigEndian.PutUint32(header, uint32(len(server.answer)))
+		if _, err := conn.Write(append(header, server.answer...)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden images of testdata/golden")
+
+const (
+	goldenDir = "testdata/golden"
+	// pixelThreshold is the perceptual distance, between 0 and 1, below which two pixels look alike
+	pixelThreshold = 0.1
+	// maxDiffRatio is the share of pixels which may look different from the golden image
+	maxDiffRatio = 0.005
+	// maxYIQDelta is the distance between black and white
+	maxYIQDelta = 35215
+)
+
+// renderStyles lists every style covered by the golden images, new styles belong here
+var renderStyles = []string{StyleAnnotated, StyleAnonymized}
+
+// goldenCases are detections rendered on the images of test_images
+var goldenCases = []struct {
+	name  string
+	file  string
+	faces []Detection
+}{
+	{
+		name: "elon",
+		file: "elon.jpg",
+		faces: []Detection{{
+			FaceCoord: RectCoord{Row: 909, Col: 298, Width: 705, Height: 987},
+			LeftEye:   Coord{Row: 1101, Col: 684},
+			RightEye:  Coord{Row: 1418, Col: 671},
+			Nose:      Coord{Row: 1268, Col: 864},
+			Mouth:     []Coord{{Row: 1137, Col: 1051}, {Row: 1402, Col: 1040}},
+		}},
+	},
+	{
+		name: "me",
+		file: "me.png",
+		faces: []Detection{{
+			FaceCoord: RectCoord{Row: 520, Col: 180, Width: 260, Height: 330},
+			LeftEye:   Coord{Row: 590, Col: 310},
+			RightEye:  Coord{Row: 710, Col: 305},
+			Nose:      Coord{Row: 650, Col: 380},
+			Mouth:     []Coord{{Row: 600, Col: 440}, {Row: 700, Col: 436}},
+		}},
+	},
+	{
+		name: "multiple_people",
+		file: "multiple_people.jpg",
+		faces: []Detection{
+			{FaceCoord: RectCoord{Row: 120, Col: 140, Width: 110, Height: 140}, LeftEye: Coord{Row: 150, Col: 195}, RightEye: Coord{Row: 210, Col: 193}},
+			{FaceCoord: RectCoord{Row: 450, Col: 120, Width: 120, Height: 150}, Nose: Coord{Row: 510, Col: 200}},
+			{FaceCoord: RectCoord{Row: 780, Col: 160, Width: 100, Height: 130}, Mouth: []Coord{{Row: 805, Col: 260}, {Row: 855, Col: 258}}},
+			// partly outside of the image
+			{FaceCoord: RectCoord{Row: 960, Col: 700, Width: 120, Height: 120}},
+		},
+	},
+	{
+		name:  "smiley_face",
+		file:  "smiley_face.jpg",
+		faces: []Detection{{FaceCoord: RectCoord{Row: 400, Col: 50, Width: 400, Height: 520}}},
+	},
+}
+
+func loadTestImage(t *testing.T, file string) image.Image {
+	content, err := ioutil.ReadFile(filepath.Join("..", "test_images", file))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("cannot decode %s: %v", file, err)
+	}
+	return img
+}
+
+// renderPNG renders the faces losslessly and decodes the result
+func renderPNG(t *testing.T, img image.Image, faces []Detection, style string) image.Image {
+	var buf bytes.Buffer
+	if err := RenderImage(img, faces, style, &buf, ".png"); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	rendered, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return rendered
+}
+
+// yiqDelta is the squared perceptual distance between two colours, weighted in the YIQ space
+func yiqDelta(a color.Color, b color.Color) float64 {
+	yiq := func(c color.Color) (float64, float64, float64) {
+		r, g, b, _ := c.RGBA()
+		red, green, blue := float64(r>>8), float64(g>>8), float64(b>>8)
+		return 0.29889531*red + 0.58662247*green + 0.11448223*blue,
+			0.59597799*red - 0.27417610*green - 0.32180189*blue,
+			0.21147017*red - 0.52261711*green + 0.31114694*blue
+	}
+	y1, i1, q1 := yiq(a)
+	y2, i2, q2 := yiq(b)
+	dy, di, dq := y1-y2, i1-i2, q1-q2
+	return 0.5053*dy*dy + 0.299*di*di + 0.1957*dq*dq
+}
+
+// diffRatio returns the share of pixels looking different on the two images
+func diffRatio(want image.Image, got image.Image) (float64, error) {
+	if want.Bounds().Size() != got.Bounds().Size() {
+		return 1, fmt.Errorf("expected a %v image, got %v", want.Bounds().Size(), got.Bounds().Size())
+	}
+	limit := maxYIQDelta * pixelThreshold * pixelThreshold
+	wantMin, gotMin := want.Bounds().Min, got.Bounds().Min
+	size := want.Bounds().Size()
+	different := 0
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if yiqDelta(want.At(wantMin.X+x, wantMin.Y+y), got.At(gotMin.X+x, gotMin.Y+y)) > limit {
+				different++
+			}
+		}
+	}
+	return float64(different) / float64(size.X*size.Y), nil
+}
+
+// checkGolden compares the rendering with testdata/golden/<name>.png, or replaces it with -update.
+// A failing rendering is written to a temporary file for inspection.
+func checkGolden(t *testing.T, name string, got image.Image) {
+	path := filepath.Join(goldenDir, name+".png")
+	if *update {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer file.Close()
+		if err := png.Encode(file, got); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("missing golden image, run go test -update: %v", err)
+	}
+	defer file.Close()
+	want, err := png.Decode(file)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	ratio, err := diffRatio(want, got)
+	if err == nil && ratio <= maxDiffRatio {
+		return
+	}
+	if actual, createErr := ioutil.TempFile("", name+"-*.png"); createErr == nil {
+		png.Encode(actual, got)
+		actual.Close()
+		t.Logf("rendered %s", actual.Name())
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	t.Fatalf("%s: %.2f%% of the pixels differ", path, ratio*100)
+}
+
+func TestRenderGolden(t *testing.T) {
+	for _, test := range goldenCases {
+		img := loadTestImage(t, test.file)
+		for _, style := range renderStyles {
+			name := test.name + "_" + style
+			t.Run(name, func(t *testing.T) {
+				checkGolden(t, name, renderPNG(t, img, test.faces, style))
+			})
+		}
+	}
+}
+
+func TestContactSheetGolden(t *testing.T) {
+	frames := []image.Image{loadTestImage(t, goldenCases[0].file), loadTestImage(t, goldenCases[2].file)}
+	faces := [][]Detection{goldenCases[0].faces, goldenCases[2].faces}
+	var buf bytes.Buffer
+	if err := RenderContactSheet(frames, faces, []string{"0.0s", "0.5s"}, &buf); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	sheet, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	checkGolden(t, "contact_sheet", sheet)
+}
+
+func TestGoldenTolerance(t *testing.T) {
+	test := goldenCases[0]
+	img := loadTestImage(t, test.file)
+	want := renderPNG(t, img, test.faces, StyleAnnotated)
+
+	// slight colour drifts, e.g. of another resampling implementation, are tolerated
+	noisy := image.NewNRGBA(want.Bounds())
+	for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+		for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+			shift := func(value uint8) uint8 {
+				if (x+y)%2 == 0 && value < 252 {
+					return value + 3
+				} else if value > 3 {
+					return value - 3
+				}
+				return value
+			}
+			noisy.SetNRGBA(x, y, color.NRGBA{R: shift(pixel.R), G: shift(pixel.G), B: shift(pixel.B), A: pixel.A})
+		}
+	}
+	if ratio, err := diffRatio(want, noisy); err != nil || ratio > 0 {
+		t.Fatalf("expected the noisy copy to look alike, %.2f%% of the pixels differ: %v", ratio*100, err)
+	}
+
+	// a face box moved by 40 pixels is not
+	moved := test.faces[0]
+	moved.FaceCoord.Row += 40
+	if ratio, err := diffRatio(want, renderPNG(t, img, []Detection{moved}, StyleAnnotated)); err != nil || ratio <= maxDiffRatio {
+		t.Fatalf("expected the moved box to be noticed, %.2f%% of the pixels differ: %v", ratio*100, err)
+	}
+}
@@ -1,14 +1,17 @@
 import select
 import socket
-import sys
-import pdb
+import struct
 import json
+import numpy as np
 from cv2 import cv2
 from mtcnn import MTCNN
 from queue import Queue
 
 HOST = '127.0.0.1'
 PORT = 3333
+# Requests and answers are framed with a 4 bytes big endian length
+HEADER_SIZE = 4
+MAX_IMAGE_SIZE = 16 * 1024 * 1024
 
 class MultiClientServer:
     def __init__(self):
@@ -16,16 +19,16 @@ class MultiClientServer:
         self.server.setblocking(0)
         self.server_address = (HOST, PORT)
         self.detector = MTCNN()
-        self.inputs, self.outputs, self.message_queues = [], [], {}
+        self.inputs, self.outputs, self.message_queues, self.buffers = [], [], {}, {}
 
-    def process_image(self, image_path):
-        image_path = image_path.decode('utf-8').strip()
+    def process_image(self, image_data):
         result = ""
-        if not image_path:
-            result = "EMPTY_IMAGE_PATH"
+        if not image_data:
+            result = "EMPTY_IMAGE"
         else:
             try:
-                img = cv2.cvtColor(cv2.imread(image_path), cv2.COLOR_BGR2RGB)
+                image = cv2.imdecode(np.frombuffer(image_data, dtype=np.uint8), cv2.IMREAD_COLOR)
+                img = cv2.cvtColor(image, cv2.COLOR_BGR2RGB)
                 result = self.detector.detect_faces(img)
                 result = json.dumps(result)
             except Exception as e:
@@ -33,6 +36,29 @@ class MultiClientServer:
                 result = "ERROR"
         return result
 
+    def next_request(self, s):
+        """Returns the next complete request of the connection, or None"""
+        buffer = self.buffers[s]
+        if len(buffer) < HEADER_SIZE:
+            return None
+        size = struct.unpack(">I", buffer[:HEADER_SIZE])[0]
+        if size > MAX_IMAGE_SIZE:
+            raise ValueError("image of %d bytes is too large" % size)
+        if len(buffer) < HEADER_SIZE + size:
+            return None
+        request = bytes(buffer[HEADER_SIZE:HEADER_SIZE + size])
+        del buffer[:HEADER_SIZE + size]
+        return request
+
+    def close(self, s):
+        if s in self.outputs:
+            self.outputs.remove(s)
+        if s in self.inputs:
+            self.inputs.remove(s)
+        s.close()
+        self.message_queues.pop(s, None)
+        self.buffers.pop(s, None)
+
     def connect(self):
         self.server.bind(self.server_address)
         self.server.listen(5)
@@ -45,33 +71,36 @@ class MultiClientServer:
                     connection.setblocking(0)
                     self.inputs.append(connection)
                     self.message_queues[connection] = Queue()
+                    self.buffers[connection] = bytearray()
                 else:
-                    data = s.recv(1024)
-                    if data:
-                        result = self.process_image(data)
-                        result = result.encode("utf-8")
-                        self.message_queues[s].put(result)
-                        if s not in self.outputs:
-                            self.outputs.append(s)
-                    else:
-                        if s in self.outputs:
-                            self.outputs.remove(s)
-                        self.inputs.remove(s)
-                        s.close()
-                        del self.message_queues[s]
+                    data = s.recv(65536)
+                    if not data:
+                        self.close(s)
+                        continue
+                    self.buffers[s].extend(data)
+                    try:
+                        request = self.next_request(s)
+                        while request is not None:
+                            result = self.process_image(request).encode("utf-8")
+                            self.message_queues[s].put(struct.pack(">I", len(result)) + result)
+                            request = self.next_request(s)
+                    except ValueError as e:
+                        print("Invalid request: ", e)
+                        self.close(s)
+                        continue
+                    if s not in self.outputs:
+                        self.outputs.append(s)
             for s in writable:
+                if s not in self.message_queues:
+                    continue
                 try:
                     next_message = self.message_queues[s].get_nowait()
                 except Exception:
                     self.outputs.remove(s)
                 else:
-                    s.send(next_message)
+                    s.sendall(next_message)
             for s in exceptional:
-                self.inputs.remove(s)
-                if s in self.outputs:
-                    self.outputs.remove(s)
-                s.close()
-                del self.message_queues[s]
+                self.close(s)
 
 if __name__ == "__main__":
     server = MultiClientServer()
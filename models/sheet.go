@@ -0,0 +1,73 @@
+package models
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+
+	pigo "github.com/esimov/pigo/core"
+	"github.com/fogleman/gg"
+	"github.com/nfnt/resize"
+)
+
+// Contact sheet layout
+const (
+	sheetColumns     = 4
+	sheetThumbWidth  = 320
+	sheetMargin      = 8
+	sheetLabelHeight = 20
+)
+
+// RenderContactSheet draws every frame with its faces as a thumbnail on a
+// grid, writes the matching label under each thumbnail and encodes the sheet
+// as a jpeg to dst
+func RenderContactSheet(frames []image.Image, faces [][]Detection, labels []string, dst io.Writer) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to render")
+	}
+
+	// Every cell is as tall as the tallest thumbnail
+	cellHeight := 0
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		height := int(math.Round(float64(sheetThumbWidth) * float64(bounds.Dy()) / float64(bounds.Dx())))
+		if height > cellHeight {
+			cellHeight = height
+		}
+	}
+
+	columns := sheetColumns
+	if len(frames) < columns {
+		columns = len(frames)
+	}
+	rows := (len(frames) + columns - 1) / columns
+	width := columns*(sheetThumbWidth+sheetMargin) + sheetMargin
+	height := rows*(cellHeight+sheetLabelHeight+sheetMargin) + sheetMargin
+
+	sheet := gg.NewContext(width, height)
+	sheet.SetColor(color.White)
+	sheet.Clear()
+
+	for i, frame := range frames {
+		src := pigo.ImgToNRGBA(frame)
+		dc := gg.NewContext(src.Bounds().Dx(), src.Bounds().Dy())
+		dc.DrawImage(src, 0, 0)
+		if i < len(faces) {
+			drawFaces(dc, faces[i])
+		}
+		thumb := resize.Resize(sheetThumbWidth, 0, dc.Image(), resize.Lanczos3)
+
+		x := sheetMargin + (i%columns)*(sheetThumbWidth+sheetMargin)
+		y := sheetMargin + (i/columns)*(cellHeight+sheetLabelHeight+sheetMargin)
+		sheet.DrawImage(thumb, x, y)
+		if i < len(labels) {
+			sheet.SetColor(color.Black)
+			sheet.DrawStringAnchored(labels[i], float64(x), float64(y+cellHeight+sheetLabelHeight/2), 0, 0.5)
+		}
+	}
+
+	return jpeg.Encode(dst, sheet.Image(), &jpeg.Options{Quality: 90})
+}
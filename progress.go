@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	events "github.com/rohith2506/facedetect/events"
+)
+
+const (
+	eventRetention    = 10 * time.Minute
+	heartbeatInterval = 3 * time.Second
+	progressIDField   = "progress_id"
+)
+
+// progress and job ids are both 128 random bits, hex encoded
+var progressIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// getProgressReporter returns the reporter for the progress id opened by the
+// client, or nil when the client is not interested in the progress
+func (app *App) getProgressReporter(c *gin.Context) (events.Reporter, error) {
+	progressID := c.PostForm(progressIDField)
+	if progressID == "" {
+		return nil, nil
+	}
+	if !progressIDRe.MatchString(progressID) || !app.Progress.Opened(progressID) {
+		return nil, errors.New("unknown progress id, open one with POST /v1/events")
+	}
+	return app.Progress.Reporter(progressID), nil
+}
+
+// EventsOpenHandler endpoint creates a progress stream and returns its id, to be sent
+// as the progress_id of a request. The id is random so that nobody else can follow it.
+func (app *App) EventsOpenHandler(c *gin.Context) {
+	progressID, err := app.Progress.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"progress creation failed": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		progressIDField: progressID,
+		"events_url":    "/v1/events/" + progressID,
+	})
+}
+
+func renderEvent(c *gin.Context, event events.Event) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.Itoa(event.ID),
+		Event: event.Stage,
+		Data:  event,
+	})
+}
+
+// EventsHandler endpoint streams the pipeline stages of a request (identified
+// by its progress_id) or of a job as server-sent events. The stream ends with
+// either a "done" or a "failed" event. Reconnecting clients sending
+// Last-Event-ID only receive the events they missed.
+func (app *App) EventsHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !progressIDRe.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid progress id": id})
+		return
+	}
+	lastEventID, _ := strconv.Atoi(c.GetHeader("Last-Event-ID"))
+
+	history, stream, cancel := app.Progress.Subscribe(id, lastEventID)
+	defer cancel()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, event := range history {
+		renderEvent(c, event)
+		if event.Terminal() {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-stream:
+			renderEvent(c, event)
+			return !event.Terminal()
+		case <-heartbeat.C:
+			// comment lines keep proxies from closing an idle stream
+			_, err := w.Write([]byte(":\n\n"))
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
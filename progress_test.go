@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func performSubmitRequestWithProgress(r http.Handler, progressID string) *httptest.ResponseRecorder {
+	params := url.Values{}
+	params.Add("image_url", "http://127.0.0.1/elon.jpg")
+	params.Add(progressIDField, progressID)
+	req, _ := http.NewRequest("POST", "/submit", strings.NewReader(params.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestProgressIDs(t *testing.T) {
+	router := SetupRouter(newTestApp(t))
+
+	w := performAdminRequest(router, "POST", "/v1/events", "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var opened struct {
+		ProgressID string `json:"progress_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &opened)
+	if !progressIDRe.MatchString(opened.ProgressID) {
+		t.Fatalf("expected a random progress id, got %s", w.Body.String())
+	}
+
+	w = performSubmitRequestWithProgress(router, opened.ProgressID)
+	if strings.Contains(w.Body.String(), "invalid progress id") {
+		t.Fatalf("expected the opened progress id to be accepted, got %s", w.Body.String())
+	}
+	// ids which were not handed out by the server are refused
+	for _, progressID := range []string{"request-1", "0123456789abcdef0123456789abcdef"} {
+		w = performSubmitRequestWithProgress(router, progressID)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid progress id") {
+			t.Fatalf("expected the progress id %s to be refused, got %s", progressID, w.Body.String())
+		}
+	}
+	w = performAdminRequest(router, "GET", "/v1/events/request-1", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,38 +1,52 @@
 package redis
 
 import (
+	"crypto/tls"
+	"net"
+	"time"
+
 	"github.com/go-redis/redis/v7"
 )
 
 const (
-	hostName = "127.0.0.1"
-	port     = "6379"
-	passWord = ""
-	dB       = 0
+	// DefaultAddr is the address used when REDIS_ADDR is not set
+	DefaultAddr = "127.0.0.1:6379"
+
+	scanBatch = 100
 )
 
+// Config describes how to reach the redis server
+type Config struct {
+	Addr     string
+	Password string
+	DB       int
+	TLS      bool
+}
+
 // Connection ...
 type Connection struct {
 	database int
 	rClient  *redis.Client
 }
 
-var connection *Connection
-
-// CreateConnection ..
-func CreateConnection(database int) *Connection {
-	if connection == nil {
-		redisClient := redis.NewClient(&redis.Options{
-			Addr:     hostName + ":" + port,
-			Password: passWord,
-			DB:       database,
-		})
-		connection = &Connection{
-			database: database,
-			rClient:  redisClient,
+// NewConnection creates a connection to the redis server described by config
+func NewConnection(config Config) *Connection {
+	options := &redis.Options{
+		Addr:     config.Addr,
+		Password: config.Password,
+		DB:       config.DB,
+	}
+	if config.TLS {
+		host, _, err := net.SplitHostPort(config.Addr)
+		if err != nil {
+			host = config.Addr
 		}
+		options.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	return &Connection{
+		database: config.DB,
+		rClient:  redis.NewClient(options),
 	}
-	return connection
 }
 
 //GetKey ...
@@ -55,3 +69,128 @@ func (conn *Connection) SetKey(key string, value interface{}) error {
 	}
 	return nil
 }
+
+// SetKeyWithExpiry sets the key which is removed by redis once expiry has passed
+func (conn *Connection) SetKeyWithExpiry(key string, value interface{}, expiry time.Duration) error {
+	return conn.rClient.Set(key, value, expiry).Err()
+}
+
+// SetKeyIfAbsent sets the key only when it does not exist yet, it reports whether it was set
+func (conn *Connection) SetKeyIfAbsent(key string, value interface{}, expiry time.Duration) (bool, error) {
+	return conn.rClient.SetNX(key, value, expiry).Result()
+}
+
+// deleteIfEquals removes a key only while it holds the expected value
+var deleteIfEquals = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// DeleteIfEqualsHash returns the sha1 of the script behind DeleteKeyIfEquals, for the fakes emulating it
+func DeleteIfEqualsHash() string {
+	return deleteIfEquals.Hash()
+}
+
+// DeleteKeyIfEquals removes the key when it still holds value, it reports whether it was removed
+func (conn *Connection) DeleteKeyIfEquals(key string, value string) (bool, error) {
+	removed, err := deleteIfEquals.Run(conn.rClient, []string{key}, value).Int()
+	return removed > 0, err
+}
+
+// DeleteKeys removes the keys and returns how many of them existed
+func (conn *Connection) DeleteKeys(keys ...string) (int64, error) {
+	return conn.rClient.Del(keys...).Result()
+}
+
+// DeleteMatching removes every key matching the glob pattern and returns how many were removed.
+// The keys are scanned in batches, so that large databases do not block the server.
+func (conn *Connection) DeleteMatching(pattern string) (int64, error) {
+	var (
+		cursor  uint64
+		removed int64
+	)
+	for {
+		keys, next, err := conn.rClient.Scan(cursor, pattern, scanBatch).Result()
+		if err != nil {
+			return removed, err
+		}
+		if len(keys) > 0 {
+			count, err := conn.rClient.Del(keys...).Result()
+			removed += count
+			if err != nil {
+				return removed, err
+			}
+		}
+		if next == 0 {
+			return removed, nil
+		}
+		cursor = next
+	}
+}
+
+// Keys returns every key matching the glob pattern, scanning them in batches
+func (conn *Connection) Keys(pattern string) ([]string, error) {
+	var (
+		cursor uint64
+		found  []string
+	)
+	for {
+		keys, next, err := conn.rClient.Scan(cursor, pattern, scanBatch).Result()
+		if err != nil {
+			return found, err
+		}
+		found = append(found, keys...)
+		if next == 0 {
+			return found, nil
+		}
+		cursor = next
+	}
+}
+
+// Ping checks whether the redis server is reachable
+func (conn *Connection) Ping() error {
+	return conn.rClient.Ping().Err()
+}
+
+// AppendToList pushes the value at the end of the list stored at key and
+// refreshes the expiry of the whole list
+func (conn *Connection) AppendToList(key string, value interface{}, expiry time.Duration) error {
+	pipe := conn.rClient.TxPipeline()
+	pipe.RPush(key, value)
+	if expiry > 0 {
+		pipe.Expire(key, expiry)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+// AddToSet adds the member to the set stored at key and refreshes the expiry of the whole set
+func (conn *Connection) AddToSet(key string, member string, expiry time.Duration) error {
+	pipe := conn.rClient.TxPipeline()
+	pipe.SAdd(key, member)
+	if expiry > 0 {
+		pipe.Expire(key, expiry)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+// RemoveFromSet removes the members from the set stored at key
+func (conn *Connection) RemoveFromSet(key string, members ...string) error {
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return conn.rClient.SRem(key, values...).Err()
+}
+
+// UnionSets returns the members of every set stored at the keys
+func (conn *Connection) UnionSets(keys ...string) ([]string, error) {
+	return conn.rClient.SUnion(keys...).Result()
+}
+
+// GetList returns every element of the list stored at key
+func (conn *Connection) GetList(key string) ([]string, error) {
+	return conn.rClient.LRange(key, 0, -1).Result()
+}
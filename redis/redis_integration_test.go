@@ -0,0 +1,19 @@
+//go:build integration
+// +build integration
+
+package redis
+
+import "testing"
+
+func TestSimpleGetAndSet(t *testing.T) {
+	conn := NewConnection(Config{Addr: DefaultAddr})
+	err := conn.SetKey("foo", "bar")
+	if err != nil {
+		t.Fatalf("Error during redis set")
+	}
+	got, _ := conn.GetKey("foo")
+	expected := "bar"
+	if expected != got {
+		t.Fatalf("Simple get and set Failed")
+	}
+}
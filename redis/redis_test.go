@@ -2,17 +2,95 @@ package redis
 
 import (
 	"testing"
+	"time"
+
+	redistest "github.com/rohith2506/facedetect/redis/redistest"
 )
 
-func TestSimpleGetAndSet(t *testing.T) {
-	conn := CreateConnection(0)
-	err := conn.SetKey("foo", "bar")
+func newTestConnection(t *testing.T) (*Connection, *redistest.Server) {
+	server, err := redistest.NewServer()
 	if err != nil {
-		t.Fatalf("Error during redis set")
+		t.Fatalf("error: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.RegisterScript(DeleteIfEqualsHash(), redistest.CompareAndDelete)
+	conn := NewConnection(Config{Addr: server.Addr})
+	if err := conn.Ping(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return conn, server
+}
+
+func TestKeys(t *testing.T) {
+	conn, server := newTestConnection(t)
+	now := time.Now()
+	server.SetNow(func() time.Time { return now })
+
+	if err := conn.SetKey("foo", "bar"); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if got, err := conn.GetKey("foo"); err != nil || got != "bar" {
+		t.Fatalf("expected bar, got %q: %v", got, err)
+	}
+	if got, err := conn.GetKey("missing"); err != nil || got != "" {
+		t.Fatalf("expected nothing for a missing key, got %q: %v", got, err)
+	}
+
+	if err := conn.SetKeyWithExpiry("short", "lived", time.Minute); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if set, err := conn.SetKeyIfAbsent("short", "again", time.Minute); err != nil || set {
+		t.Fatalf("expected the key to be kept, got %v: %v", set, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if set, err := conn.SetKeyIfAbsent("short", "again", time.Minute); err != nil || !set {
+		t.Fatalf("expected the expired key to be replaced, got %v: %v", set, err)
+	}
+
+	if removed, err := conn.DeleteKeyIfEquals("short", "lived"); err != nil || removed {
+		t.Fatalf("expected the key to be kept, got %v: %v", removed, err)
+	}
+	if removed, err := conn.DeleteKeyIfEquals("short", "again"); err != nil || !removed {
+		t.Fatalf("expected the key to be removed, got %v: %v", removed, err)
+	}
+
+	for _, key := range []string{"faces:a", "faces:b", "other"} {
+		if err := conn.SetKey(key, "1"); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	if keys, err := conn.Keys("faces:*"); err != nil || len(keys) != 2 {
+		t.Fatalf("expected two keys, got %v: %v", keys, err)
+	}
+	if removed, err := conn.DeleteMatching("faces:*"); err != nil || removed != 2 {
+		t.Fatalf("expected two keys removed, got %d: %v", removed, err)
+	}
+	if removed, err := conn.DeleteKeys("foo", "other", "faces:a"); err != nil || removed != 2 {
+		t.Fatalf("expected two keys removed, got %d: %v", removed, err)
+	}
+}
+
+func TestListsAndSets(t *testing.T) {
+	conn, _ := newTestConnection(t)
+
+	for _, value := range []string{"first", "second"} {
+		if err := conn.AppendToList("events", value, time.Minute); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	if list, err := conn.GetList("events"); err != nil || len(list) != 2 || list[0] != "first" || list[1] != "second" {
+		t.Fatalf("unexpected list %v: %v", list, err)
+	}
+
+	for key, member := range map[string]string{"band:1": "a", "band:2": "b"} {
+		if err := conn.AddToSet(key, member, time.Minute); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	if err := conn.AddToSet("band:2", "a", 0); err != nil {
+		t.Fatalf("error: %v", err)
 	}
-	got, _ := conn.GetKey("foo")
-	expected := "bar"
-	if expected != got {
-		t.Fatalf("Simple get and set Failed")
+	if members, err := conn.UnionSets("band:1", "band:2", "band:3"); err != nil || len(members) != 2 {
+		t.Fatalf("expected two members, got %v: %v", members, err)
 	}
 }
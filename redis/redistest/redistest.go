@@ -0,0 +1,530 @@
+package redistest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Script emulates a lua script of the application, it runs atomically like the real one
+type Script func(db *DB, keys []string, args []string) (interface{}, error)
+
+// Server is an in-process redis server speaking enough of the protocol for the
+// commands used by the application: strings, lists and sets with expiries, scans,
+// transactions and the scripts registered with RegisterScript.
+type Server struct {
+	Addr string
+
+	listener net.Listener
+	mutex    sync.Mutex
+	db       *DB
+	scripts  map[string]Script
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// DB holds the data of the server, it is handed to the scripts
+type DB struct {
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+type entry struct {
+	value   string
+	list    []string
+	set     map[string]struct{}
+	expires time.Time
+}
+
+type errReply string
+
+var errSyntax = errReply("ERR syntax error")
+
+// NewServer starts a server on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		db:       &DB{entries: make(map[string]*entry), now: time.Now},
+		scripts:  make(map[string]Script),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go server.serve()
+	return server, nil
+}
+
+// CompareAndDelete emulates the script of redis.DeleteKeyIfEquals, whose hash is redis.DeleteIfEqualsHash
+func CompareAndDelete(db *DB, keys []string, args []string) (interface{}, error) {
+	if len(keys) != 1 || len(args) != 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	if value, found := db.Get(keys[0]); found && value == args[0] {
+		return int64(db.Del(keys[0])), nil
+	}
+	return int64(0), nil
+}
+
+// RegisterScript emulates the lua script with the given sha1, as returned by redis.Script.Hash
+func (server *Server) RegisterScript(sha string, script Script) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.scripts[sha] = script
+}
+
+// SetNow replaces the clock deciding when the keys expire
+func (server *Server) SetNow(now func() time.Time) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.db.now = now
+}
+
+// Close stops the server and drops the connections
+func (server *Server) Close() error {
+	server.mutex.Lock()
+	server.closed = true
+	for conn := range server.conns {
+		conn.Close()
+	}
+	server.mutex.Unlock()
+	return server.listener.Close()
+}
+
+func (server *Server) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.mutex.Lock()
+		if server.closed {
+			server.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		server.conns[conn] = struct{}{}
+		server.mutex.Unlock()
+		go server.handle(conn)
+	}
+}
+
+func (server *Server) handle(conn net.Conn) {
+	defer func() {
+		server.mutex.Lock()
+		delete(server.conns, conn)
+		server.mutex.Unlock()
+		conn.Close()
+	}()
+
+	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	var queued [][]string
+	inTransaction := false
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "MULTI":
+			inTransaction, queued = true, nil
+			writeReply(writer, "OK")
+		case name == "EXEC" && inTransaction:
+			replies := make([]interface{}, len(queued))
+			server.mutex.Lock()
+			for i, command := range queued {
+				replies[i] = server.execute(command)
+			}
+			server.mutex.Unlock()
+			inTransaction = false
+			writeReply(writer, replies)
+		case name == "DISCARD" && inTransaction:
+			inTransaction = false
+			writeReply(writer, "OK")
+		case inTransaction:
+			queued = append(queued, args)
+			writeReply(writer, "QUEUED")
+		default:
+			server.mutex.Lock()
+			reply := server.execute(args)
+			server.mutex.Unlock()
+			writeReply(writer, reply)
+		}
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a command, the caller holds the mutex
+func (server *Server) execute(args []string) interface{} {
+	db := server.db
+	name, args := strings.ToUpper(args[0]), args[1:]
+	switch name {
+	case "PING":
+		return "PONG"
+	case "SELECT", "AUTH":
+		return "OK"
+	case "GET":
+		if len(args) != 1 {
+			return errSyntax
+		}
+		if value, found := db.Get(args[0]); found {
+			return []byte(value)
+		}
+		return nil
+	case "SET":
+		return db.set(args)
+	case "SETNX":
+		if len(args) != 2 {
+			return errSyntax
+		}
+		if _, found := db.Get(args[0]); found {
+			return int64(0)
+		}
+		db.Set(args[0], args[1], 0)
+		return int64(1)
+	case "DEL":
+		return int64(db.Del(args...))
+	case "EXPIRE":
+		if len(args) != 2 {
+			return errSyntax
+		}
+		seconds, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errSyntax
+		}
+		current := db.lookup(args[0])
+		if current == nil {
+			return int64(0)
+		}
+		current.expires = db.now().Add(time.Duration(seconds) * time.Second)
+		return int64(1)
+	case "SCAN":
+		return db.scan(args)
+	case "KEYS":
+		if len(args) != 1 {
+			return errSyntax
+		}
+		return toReplies(db.Keys(args[0]))
+	case "RPUSH":
+		if len(args) < 2 {
+			return errSyntax
+		}
+		current := db.lookup(args[0])
+		if current == nil {
+			current = &entry{}
+			db.entries[args[0]] = current
+		}
+		current.list = append(current.list, args[1:]...)
+		return int64(len(current.list))
+	case "LRANGE":
+		return db.lrange(args)
+	case "SADD":
+		if len(args) < 2 {
+			return errSyntax
+		}
+		current := db.lookup(args[0])
+		if current == nil {
+			current = &entry{set: make(map[string]struct{})}
+			db.entries[args[0]] = current
+		}
+		added := 0
+		for _, member := range args[1:] {
+			if _, found := current.set[member]; !found {
+				current.set[member] = struct{}{}
+				added++
+			}
+		}
+		return int64(added)
+	case "SREM":
+		if len(args) < 2 {
+			return errSyntax
+		}
+		current := db.lookup(args[0])
+		if current == nil {
+			return int64(0)
+		}
+		removed := 0
+		for _, member := range args[1:] {
+			if _, found := current.set[member]; found {
+				delete(current.set, member)
+				removed++
+			}
+		}
+		if len(current.set) == 0 {
+			delete(db.entries, args[0])
+		}
+		return int64(removed)
+	case "SUNION", "SMEMBERS":
+		union := make(map[string]struct{})
+		for _, key := range args {
+			if current := db.lookup(key); current != nil {
+				for member := range current.set {
+					union[member] = struct{}{}
+				}
+			}
+		}
+		members := make([]string, 0, len(union))
+		for member := range union {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return toReplies(members)
+	case "EVALSHA", "EVAL":
+		if len(args) < 2 {
+			return errSyntax
+		}
+		sha := args[0]
+		if name == "EVAL" {
+			digest := sha1.Sum([]byte(args[0]))
+			sha = hex.EncodeToString(digest[:])
+		}
+		script, found := server.scripts[sha]
+		if !found {
+			return errReply("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		count, err := strconv.Atoi(args[1])
+		if err != nil || count < 0 || count > len(args)-2 {
+			return errSyntax
+		}
+		reply, err := script(db, args[2:2+count], args[2+count:])
+		if err != nil {
+			return errReply("ERR " + err.Error())
+		}
+		return reply
+	}
+	return errReply(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+}
+
+// lookup returns the live entry of key, removing it once expired
+func (db *DB) lookup(key string) *entry {
+	current, found := db.entries[key]
+	if !found {
+		return nil
+	}
+	if !current.expires.IsZero() && !db.now().Before(current.expires) {
+		delete(db.entries, key)
+		return nil
+	}
+	return current
+}
+
+// Get returns the string stored at key
+func (db *DB) Get(key string) (string, bool) {
+	current := db.lookup(key)
+	if current == nil || current.list != nil || current.set != nil {
+		return "", false
+	}
+	return current.value, true
+}
+
+// Set stores the string at key, it expires after ttl unless ttl is zero
+func (db *DB) Set(key string, value string, ttl time.Duration) {
+	current := &entry{value: value}
+	if ttl > 0 {
+		current.expires = db.now().Add(ttl)
+	}
+	db.entries[key] = current
+}
+
+// Del removes the keys and returns how many of them existed
+func (db *DB) Del(keys ...string) int {
+	removed := 0
+	for _, key := range keys {
+		if db.lookup(key) != nil {
+			delete(db.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Keys returns the sorted keys matching the glob pattern
+func (db *DB) Keys(pattern string) []string {
+	var keys []string
+	for key := range db.entries {
+		if db.lookup(key) == nil {
+			continue
+		}
+		if matched, _ := path.Match(pattern, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// set implements SET key value [EX seconds | PX milliseconds] [NX | XX]
+func (db *DB) set(args []string) interface{} {
+	if len(args) < 2 {
+		return errSyntax
+	}
+	var (
+		ttl        time.Duration
+		onlyAbsent bool
+		onlyExists bool
+	)
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			amount, err := strconv.Atoi(args[i+1])
+			if err != nil || amount <= 0 {
+				return errReply("ERR invalid expire time in set")
+			}
+			unit := time.Second
+			if strings.ToUpper(args[i]) == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(amount) * unit
+			i++
+		case "NX":
+			onlyAbsent = true
+		case "XX":
+			onlyExists = true
+		default:
+			return errSyntax
+		}
+	}
+	exists := db.lookup(args[0]) != nil
+	if (onlyAbsent && exists) || (onlyExists && !exists) {
+		return nil
+	}
+	db.Set(args[0], args[1], ttl)
+	return "OK"
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count], answering every key at once
+func (db *DB) scan(args []string) interface{} {
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		if strings.ToUpper(args[i]) == "MATCH" {
+			pattern = args[i+1]
+		}
+	}
+	return []interface{}{[]byte("0"), toReplies(db.Keys(pattern))}
+}
+
+func (db *DB) lrange(args []string) interface{} {
+	if len(args) != 3 {
+		return errSyntax
+	}
+	start, err1 := strconv.Atoi(args[1])
+	stop, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return errSyntax
+	}
+	current := db.lookup(args[0])
+	if current == nil {
+		return []interface{}{}
+	}
+	length := len(current.list)
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return []interface{}{}
+	}
+	return toReplies(current.list[start : stop+1])
+}
+
+func toReplies(values []string) []interface{} {
+	replies := make([]interface{}, len(values))
+	for i, value := range values {
+		replies[i] = []byte(value)
+	}
+	return replies
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, errors.New("inline commands are not supported")
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid command length %q", line)
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk string length %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// writeReply encodes a reply: strings are status replies, byte slices bulk strings and nil the nil bulk string
+func writeReply(writer *bufio.Writer, reply interface{}) {
+	switch reply := reply.(type) {
+	case nil:
+		writer.WriteString("$-1\r\n")
+	case string:
+		writer.WriteString("+" + reply + "\r\n")
+	case errReply:
+		writer.WriteString("-" + string(reply) + "\r\n")
+	case int64:
+		writer.WriteString(":" + strconv.FormatInt(reply, 10) + "\r\n")
+	case []byte:
+		writer.WriteString("$" + strconv.Itoa(len(reply)) + "\r\n")
+		writer.Write(reply)
+		writer.WriteString("\r\n")
+	case []interface{}:
+		writer.WriteString("*" + strconv.Itoa(len(reply)) + "\r\n")
+		for _, element := range reply {
+			writeReply(writer, element)
+		}
+	default:
+		writer.WriteString(fmt.Sprintf("-ERR unsupported reply %T\r\n", reply))
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	cache "github.com/rohith2506/facedetect/cache"
+	storage "github.com/rohith2506/facedetect/storage"
+)
+
+// The janitor removes the images older than the retention period of the image store
+const janitorInterval = time.Hour
+
+// imageKeys returns every key under which an image derived from the source image with the given hash may be stored
+func imageKeys(imageHash string) []string {
+	keys := make([]string, 0, len(availableExtensions)+1)
+	for _, extension := range availableExtensions {
+		keys = append(keys, imageHash+extension)
+	}
+	return append(keys, imageHash+contactSheetSuffix)
+}
+
+// imageHashFromKey returns the source image hash of a stored key, or an empty string for keys
+// which were not stored by us, e.g. other objects of a shared bucket
+func imageHashFromKey(key string) string {
+	if len(key) < 32 || !imageHashRe.MatchString(key[:32]) {
+		return ""
+	}
+	for _, ownKey := range imageKeys(key[:32]) {
+		if key == ownKey {
+			return key[:32]
+		}
+	}
+	return ""
+}
+
+// forgetDetection removes the cached detection of the source image with the given hash
+func (app *App) forgetDetection(imageHash string) (bool, error) {
+	removed, err := app.Cache.DeleteMatching(app.imageDetectionPattern(imageHash))
+	return removed > 0, err
+}
+
+// forgetImage removes everything known about the source image with the given hash: its cached
+// detections, its entry of the similarity index, the records of the urls it was fetched from
+// and the jobs which detected it
+func (app *App) forgetImage(imageHash string) (bool, error) {
+	if err := app.Index.Remove(imageHash); err != nil {
+		return false, err
+	}
+	forgotten, err := app.forgetDetection(imageHash)
+	if err != nil {
+		return forgotten, err
+	}
+
+	refs, err := app.Cache.Keys(app.imageRefPattern(imageHash))
+	if err != nil {
+		return forgotten, err
+	}
+	prefix := strings.TrimSuffix(app.imageRefPattern(imageHash), "*")
+	for _, ref := range refs {
+		parts := strings.SplitN(strings.TrimPrefix(ref, prefix), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch kind, id := parts[0], parts[1]; kind {
+		case urlRef:
+			removed, err := app.forgetURLRecord(id, imageHash)
+			if err != nil {
+				return forgotten, err
+			}
+			forgotten = forgotten || removed
+		case jobRef:
+			if app.Jobs == nil {
+				continue
+			}
+			removed, err := app.Jobs.Delete(id)
+			if err != nil {
+				return forgotten, err
+			}
+			forgotten = forgotten || removed
+		}
+	}
+	if len(refs) > 0 {
+		if _, err := app.Cache.Delete(refs...); err != nil {
+			return forgotten, err
+		}
+	}
+	return forgotten, nil
+}
+
+// forgetURLRecord removes the record of the url with the given digest unless
+// the url now points at another image
+func (app *App) forgetURLRecord(digest string, imageHash string) (bool, error) {
+	value, err := app.Cache.Get(app.urlKey(digest))
+	if err == cache.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var record urlRecord
+	if err := json.Unmarshal(value, &record); err == nil && record.ImageHash != imageHash {
+		return false, nil
+	}
+	removed, err := app.Cache.Delete(app.urlKey(digest))
+	return removed > 0, err
+}
+
+// deleteImage removes the rendered images and the cached detection of the source
+// image with the given hash. It returns the keys which were removed from the store.
+func deleteImage(store storage.ImageStore, imageHash string, forget func(string) (bool, error)) ([]string, bool, error) {
+	var deleted []string
+	for _, key := range imageKeys(imageHash) {
+		exists, err := store.Exists(key)
+		if err != nil {
+			return deleted, false, err
+		}
+		if !exists {
+			continue
+		}
+		if err := store.Delete(key); err != nil {
+			return deleted, false, err
+		}
+		deleted = append(deleted, key)
+	}
+	forgotten, err := forget(imageHash)
+	return deleted, forgotten, err
+}
+
+// sweepImages removes the images stored before cutoff along with their cached detections.
+// Foreign keys are left alone, the store may share its bucket or directory.
+func sweepImages(store storage.ImageStore, cutoff time.Time, forget func(string) (bool, error)) (int, error) {
+	keys, err := store.List(cutoff)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, key := range keys {
+		imageHash := imageHashFromKey(key)
+		if imageHash == "" {
+			continue
+		}
+		if err := store.Delete(key); err != nil {
+			return removed, err
+		}
+		removed++
+		if _, err := forget(imageHash); err != nil {
+			log.Printf("Error in removing the cached detection of %s: %v", key, err)
+		}
+	}
+	return removed, nil
+}
+
+// startJanitor periodically removes the images which are older than the retention period
+func (app *App) startJanitor() {
+	go func() {
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
+		for {
+			removed, err := sweepImages(app.Store, time.Now().Add(-app.Retention), app.forgetDetection)
+			if err != nil {
+				log.Printf("Error in removing expired images: %v", err)
+			} else if removed > 0 {
+				log.Printf("Removed %d expired images", removed)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// ImageDeleteHandler endpoint erases the rendered images of the source image with the given hash
+// and everything cached about it
+func (app *App) ImageDeleteHandler(c *gin.Context) {
+	imageHash := c.Param("hash")
+	if !imageHashRe.MatchString(imageHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid image hash": imageHash})
+		return
+	}
+
+	deleted, forgotten, err := deleteImage(app.Store, imageHash, app.forgetImage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"image deletion failed": err.Error()})
+		return
+	}
+	if len(deleted) == 0 && !forgotten {
+		c.JSON(http.StatusNotFound, gin.H{"image not found": imageHash})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"deleted":      deleted,
+		"cache_purged": forgotten,
+	})
+}
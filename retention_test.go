@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	cache "github.com/rohith2506/facedetect/cache"
+	fetch "github.com/rohith2506/facedetect/fetch"
+	jobs "github.com/rohith2506/facedetect/jobs"
+	storage "github.com/rohith2506/facedetect/storage"
+	utilities "github.com/rohith2506/facedetect/utilities"
+)
+
+const testImageHash = "0123456789abcdef0123456789abcdef"
+
+func createRetentionStore(t *testing.T) *storage.LocalStore {
+	dir, err := ioutil.TempDir("", "facedetect-retention")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store, err := storage.NewLocalStore(dir, localImagesPath, []byte("secret"), time.Hour)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for _, key := range []string{testImageHash + ".jpg", "other.png", "backup.tar", testImageHash + "_notes.txt"} {
+		if err := store.Put(key, strings.NewReader("jpeg"), storage.ImageInfo{}); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	// the foreign objects of a shared store are as old as the expired image
+	past := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{testImageHash + ".jpg", "backup.tar", testImageHash + "_notes.txt"} {
+		if err := os.Chtimes(filepath.Join(dir, key), past, past); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	return store
+}
+
+func TestDeleteImage(t *testing.T) {
+	store := createRetentionStore(t)
+	var forgotten []string
+	forget := func(imageHash string) (bool, error) {
+		forgotten = append(forgotten, imageHash)
+		return true, nil
+	}
+
+	deleted, purged, err := deleteImage(store, testImageHash, forget)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != testImageHash+".jpg" || !purged {
+		t.Fatalf("unexpected deletion %v, cache purged: %v", deleted, purged)
+	}
+	if len(forgotten) != 1 || forgotten[0] != testImageHash {
+		t.Fatalf("expected the cached detection to be removed, got %v", forgotten)
+	}
+	if exists, _ := store.Exists(testImageHash + ".jpg"); exists {
+		t.Fatalf("expected the image to be deleted")
+	}
+}
+
+func TestSweepImages(t *testing.T) {
+	store := createRetentionStore(t)
+	var forgotten []string
+	forget := func(imageHash string) (bool, error) {
+		forgotten = append(forgotten, imageHash)
+		return true, nil
+	}
+
+	removed, err := sweepImages(store, time.Now().Add(-24*time.Hour), forget)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if removed != 1 || len(forgotten) != 1 || forgotten[0] != testImageHash {
+		t.Fatalf("expected the expired image to be removed, got %d %v", removed, forgotten)
+	}
+	if exists, _ := store.Exists("other.png"); !exists {
+		t.Fatalf("expected the recent image to be kept")
+	}
+	for _, key := range []string{"backup.tar", testImageHash + "_notes.txt"} {
+		if exists, _ := store.Exists(key); !exists {
+			t.Fatalf("expected the foreign object %s to be kept", key)
+		}
+	}
+}
+
+func TestImageDeleteHandler(t *testing.T) {
+	app := newTestApp(t)
+	app.AdminToken = "secret"
+	jobStore := jobs.NewMemoryStore(jobTTL)
+	app.Jobs = jobs.NewPool(jobStore, 1, 1)
+
+	imageData, err := ioutil.ReadFile("test_images/elon.jpg")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	imageHash, perceptualHash := utilities.GetBytesHash(imageData), utilities.DHash(img)
+
+	// the job runs right here rather than on a worker, so the test does not depend on the speed of the detection
+	job := &jobs.Job{ID: "0123456789abcdef", Status: jobs.StatusRunning, UpdatedAt: time.Now()}
+	result, err := app.detectionTask(imageData, "", ".jpg")(job)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	job.Status, job.Result = jobs.StatusDone, result
+	if err := jobStore.Save(job); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	imageURL := "http://example.com/elon.jpg"
+	app.saveURLRecord(imageURL, urlRecord{Validators: fetch.Validators{ETag: `"1"`}, ImageHash: imageHash, FetchedAt: time.Now()})
+	if app.getURLRecord(imageURL) == nil {
+		t.Fatalf("expected the url record to be saved")
+	}
+
+	router := SetupRouter(app)
+	w := performAdminRequest(router, "DELETE", "/v1/images/"+imageHash, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performAdminRequest(router, "DELETE", "/v1/images/"+imageHash, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if exists, _ := app.Store.Exists(imageHash + ".jpg"); exists {
+		t.Fatalf("expected the rendered image to be deleted")
+	}
+	if keys, _ := app.Cache.Keys(app.imageDetectionPattern(imageHash)); len(keys) != 0 {
+		t.Fatalf("expected the detections to be forgotten, got %v", keys)
+	}
+	if _, _, err := app.Index.Nearest(perceptualHash, 0); err != cache.ErrNotFound {
+		t.Fatalf("expected the perceptual hash to be forgotten, got %v", err)
+	}
+	if app.getURLRecord(imageURL) != nil {
+		t.Fatalf("expected the url record to be forgotten")
+	}
+	if _, err := app.Jobs.Get(job.ID); err != jobs.ErrJobNotFound {
+		t.Fatalf("expected the job to be forgotten, got %v", err)
+	}
+	if keys, _ := app.Cache.Keys(app.imageRefPattern(imageHash)); len(keys) != 0 {
+		t.Fatalf("expected the references to be removed, got %v", keys)
+	}
+
+	w = performAdminRequest(router, "DELETE", "/v1/images/"+imageHash, "secret")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestImageDeleteHandlerRedis(t *testing.T) {
+	app, _ := newSharedApp(t, newLockServer(t))
+	app.AdminToken = "secret"
+	imageData := readElon(t)
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	imageHash, perceptualHash := utilities.GetBytesHash(imageData), utilities.DHash(img)
+
+	router := SetupRouter(app)
+	w := performUploadRequest(router, "POST", "/upload")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if id, _, err := app.Index.Nearest(perceptualHash, 0); err != nil || id != imageHash {
+		t.Fatalf("expected the image to be indexed, got %s: %v", id, err)
+	}
+	// the index is not taken for a cached detection
+	w = performAdminRequest(router, "GET", "/v1/admin/cache/stats", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if !strings.Contains(w.Body.String(), `"entries":1`) {
+		t.Fatalf("expected a single cached detection, got %s", w.Body.String())
+	}
+
+	w = performAdminRequest(router, "DELETE", "/v1/images/"+imageHash, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if _, _, err := app.Index.Nearest(perceptualHash, 0); err != cache.ErrNotFound {
+		t.Fatalf("expected the perceptual hash to be forgotten, got %v", err)
+	}
+
+	// dropping the model drops its index as well
+	w = performUploadRequest(router, "POST", "/upload")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performAdminRequest(router, "DELETE", "/v1/admin/cache/models/mtcnn/1", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if _, _, err := app.Index.Nearest(perceptualHash, 0); err != cache.ErrNotFound {
+		t.Fatalf("expected the index of the model to be dropped, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DefaultBucket is the bucket used when S3_BUCKET is not set
+const DefaultBucket = "facedetection25"
+
+// presigned urls cannot be valid for longer than a week
+const maxURLExpiry = 7 * 24 * time.Hour
+
+var storageClasses = []string{
+	s3.StorageClassStandard,
+	s3.StorageClassReducedRedundancy,
+	s3.StorageClassStandardIa,
+	s3.StorageClassOnezoneIa,
+	s3.StorageClassIntelligentTiering,
+	s3.StorageClassGlacier,
+	s3.StorageClassDeepArchive,
+}
+
+// Config describes where and how the images are stored. Endpoint and
+// PathStyle allow pointing at s3 compatible services such as MinIO.
+type Config struct {
+	Region       string
+	Endpoint     string
+	PathStyle    bool
+	Bucket       string
+	KeyPrefix    string
+	SSE          string
+	KMSKeyID     string
+	StorageClass string
+	URLExpiry    time.Duration
+}
+
+// Validate ...
+func (config Config) Validate() error {
+	if config.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	switch config.SSE {
+	case "", s3.ServerSideEncryptionAes256:
+		if config.KMSKeyID != "" {
+			return fmt.Errorf("a kms key id requires %s server side encryption", s3.ServerSideEncryptionAwsKms)
+		}
+	case s3.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("unknown server side encryption %q, possible values are [%s, %s]",
+			config.SSE, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+	if config.URLExpiry < 0 || config.URLExpiry > maxURLExpiry {
+		return fmt.Errorf("url expiry must be between 0 and %v", maxURLExpiry)
+	}
+	if config.StorageClass != "" {
+		found := false
+		for _, storageClass := range storageClasses {
+			found = found || storageClass == config.StorageClass
+		}
+		if !found {
+			return fmt.Errorf("unknown storage class %q, possible values are [%s]",
+				config.StorageClass, strings.Join(storageClasses, ", "))
+		}
+	}
+	return nil
+}
+
+// objectKey prefixes the key with the configured key prefix
+func (config Config) objectKey(key string) string {
+	if config.KeyPrefix == "" {
+		return key
+	}
+	return strings.TrimSuffix(config.KeyPrefix, "/") + "/" + key
+}
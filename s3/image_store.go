@@ -1,82 +1,180 @@
 package s3
 
 import (
-	"fmt"
-	"log"
-	"os"
+	"io"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-)
-
-const (
-	awsRegion = "AWS_REGION"
-	prodEnv   = "default"
+	storage "github.com/rohith2506/facedetect/storage"
 )
 
 // Connection ...
 type Connection struct {
-	env  string
-	sess *session.Session
-}
-
-func exitErrorf(msg string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, msg+"\n", args...)
-	os.Exit(1)
+	sess   *session.Session
+	config Config
 }
 
-var connection *Connection
-
-// GetAwsSession ...
-func GetAwsSession(environment string) *Connection {
-	if connection == nil {
-		sess, err := session.NewSession(&aws.Config{
-			Region:      aws.String(os.Getenv(awsRegion)),
-			Credentials: credentials.NewEnvCredentials(),
-		})
-		if err != nil {
-			log.Fatalf("Error in creating aws session: %v", err)
-		}
-		connection = &Connection{
-			env:  environment,
-			sess: sess,
-		}
+// NewConnection creates an aws session for the given configuration
+func NewConnection(config Config) (*Connection, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
-	return connection
-}
-
-// UploadFile ...
-func (conn *Connection) UploadFile(imagePath string, imageID string, bucket string) error {
-	uploader := s3manager.NewUploader(conn.sess)
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return err
+	awsConfig := &aws.Config{
+		Region:           aws.String(config.Region),
+		Credentials:      credentials.NewEnvCredentials(),
+		S3ForcePathStyle: aws.Bool(config.PathStyle),
 	}
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(imageID),
-		Body:   file,
-	})
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	sess, err := session.NewSession(awsConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return &Connection{
+		sess:   sess,
+		config: config,
+	}, nil
+}
+
+// Bucket returns the configured bucket
+func (conn *Connection) Bucket() string {
+	return conn.config.Bucket
 }
 
-// GetImageURL ...
+// GetImageURL presigns a download url, valid for the configured url expiry
 func (conn *Connection) GetImageURL(imageID string, bucket string) (string, error) {
 	svc := s3.New(conn.sess)
 	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(imageID),
 	})
-	urlStr, err := req.Presign(100 * time.Hour)
+	expiry := conn.config.URLExpiry
+	if expiry == 0 {
+		expiry = storage.DefaultURLExpiry
+	}
+	urlStr, err := req.Presign(expiry)
 	if err != nil {
 		return "", err
 	}
 	return urlStr, nil
 }
+
+// Store is the s3 backed image store. Every image is kept in the configured
+// bucket, under the key prefix, with the configured encryption and storage class.
+type Store struct {
+	conn   *Connection
+	config Config
+}
+
+// NewStore ...
+func NewStore(conn *Connection) *Store {
+	return &Store{conn: conn, config: conn.config}
+}
+
+// Put ...
+func (store *Store) Put(key string, body io.Reader, info storage.ImageInfo) error {
+	uploader := s3manager.NewUploader(store.conn.sess)
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(store.config.Bucket),
+		Key:    aws.String(store.config.objectKey(key)),
+		Body:   body,
+	}
+	if info.ContentType != "" {
+		input.ContentType = aws.String(info.ContentType)
+	}
+	if info.CacheControl != "" {
+		input.CacheControl = aws.String(info.CacheControl)
+	}
+	if len(info.Metadata) > 0 {
+		input.Metadata = aws.StringMap(info.Metadata)
+	}
+	if store.config.SSE != "" {
+		input.ServerSideEncryption = aws.String(store.config.SSE)
+	}
+	if store.config.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(store.config.KMSKeyID)
+	}
+	if store.config.StorageClass != "" {
+		input.StorageClass = aws.String(store.config.StorageClass)
+	}
+	_, err := uploader.Upload(input)
+	return err
+}
+
+// URL ...
+func (store *Store) URL(key string) (string, error) {
+	return store.conn.GetImageURL(store.config.objectKey(key), store.config.Bucket)
+}
+
+// Delete ...
+func (store *Store) Delete(key string) error {
+	svc := s3.New(store.conn.sess)
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(store.config.Bucket),
+		Key:    aws.String(store.config.objectKey(key)),
+	})
+	return err
+}
+
+// Exists ...
+func (store *Store) Exists(key string) (bool, error) {
+	_, err := store.Stat(key)
+	if err == storage.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Stat ...
+func (store *Store) Stat(key string) (storage.ImageInfo, error) {
+	var info storage.ImageInfo
+	svc := s3.New(store.conn.sess)
+	output, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(store.config.Bucket),
+		Key:    aws.String(store.config.objectKey(key)),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.RequestFailure); ok && awsErr.StatusCode() == http.StatusNotFound {
+			return info, storage.ErrNotFound
+		}
+		return info, err
+	}
+
+	info.ContentType = aws.StringValue(output.ContentType)
+	info.CacheControl = aws.StringValue(output.CacheControl)
+	info.Size = aws.Int64Value(output.ContentLength)
+	info.LastModified = aws.TimeValue(output.LastModified)
+	// s3 canonicalizes the metadata keys, e.g. Source-Hash
+	info.Metadata = make(map[string]string, len(output.Metadata))
+	for name, value := range output.Metadata {
+		info.Metadata[strings.ToLower(name)] = aws.StringValue(value)
+	}
+	return info, nil
+}
+
+// List returns the keys of the images below the key prefix last modified before the given time
+func (store *Store) List(before time.Time) ([]string, error) {
+	var keys []string
+	prefix := store.config.objectKey("")
+	svc := s3.New(store.conn.sess)
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(store.config.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if aws.TimeValue(object.LastModified).Before(before) {
+				keys = append(keys, strings.TrimPrefix(aws.StringValue(object.Key), prefix))
+			}
+		}
+		return true
+	})
+	return keys, err
+}
@@ -0,0 +1,28 @@
+//go:build integration
+// +build integration
+
+package s3
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	storage "github.com/rohith2506/facedetect/storage"
+)
+
+func TestGetImageURL(t *testing.T) {
+	config := Config{Region: os.Getenv("AWS_REGION"), Bucket: DefaultBucket, URLExpiry: storage.DefaultURLExpiry}
+	connection, err := NewConnection(config)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	wanted := "https://facedetection25.s3.eu-central-1.amazonaws.com/elon.jpg"
+	expected, err := connection.GetImageURL("elon.jpg", "facedetection25")
+	if err != nil {
+		t.Fatalf("error: %v", err.Error())
+	}
+	if strings.Contains(wanted, expected) {
+		t.Fail()
+	}
+}
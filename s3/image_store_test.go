@@ -1,18 +1,104 @@
 package s3
 
 import (
+	"os"
 	"strings"
 	"testing"
+	"time"
+
+	s3test "github.com/rohith2506/facedetect/s3/s3test"
+	storage "github.com/rohith2506/facedetect/storage"
 )
 
-func TestGetImageURL(t *testing.T) {
-	connection := GetAwsSession("default")
-	wanted := "https://facedetection25.s3.eu-central-1.amazonaws.com/elon.jpg"
-	expected, err := connection.GetImageURL("elon.jpg", "facedetection25")
+func TestConfigValidate(t *testing.T) {
+	config := Config{Bucket: DefaultBucket, SSE: "aws:kms", KMSKeyID: "key", StorageClass: "STANDARD_IA"}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for _, invalid := range []Config{
+		{},
+		{Bucket: DefaultBucket, SSE: "rot13"},
+		{Bucket: DefaultBucket, SSE: "AES256", KMSKeyID: "key"},
+		{Bucket: DefaultBucket, StorageClass: "FAST"},
+		{Bucket: DefaultBucket, URLExpiry: 8 * 24 * time.Hour},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func newTestStore(t *testing.T) (*Store, *s3test.Server) {
+	server := s3test.NewServer()
+	t.Cleanup(server.Close)
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Cleanup(func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	})
+
+	conn, err := NewConnection(Config{
+		Region:    "eu-central-1",
+		Endpoint:  server.URL,
+		PathStyle: true,
+		Bucket:    DefaultBucket,
+		KeyPrefix: "faces/",
+	})
 	if err != nil {
-		t.Fatalf("error: %v", err.Error())
+		t.Fatalf("error: %v", err)
+	}
+	return NewStore(conn), server
+}
+
+func TestStore(t *testing.T) {
+	store, server := newTestStore(t)
+	now := time.Now().Add(-time.Hour).Truncate(time.Second)
+	server.SetNow(func() time.Time { return now })
+
+	info := storage.ImageInfo{
+		ContentType:  "image/jpeg",
+		CacheControl: "max-age=60",
+		Metadata:     map[string]string{"source-hash": "0123"},
+	}
+	if err := store.Put("elon.jpg", strings.NewReader("jpeg"), info); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if object, found := server.Object(DefaultBucket, "faces/elon.jpg"); !found || string(object.Body) != "jpeg" {
+		t.Fatalf("expected the image below the key prefix")
+	}
+
+	stat, err := store.Stat("elon.jpg")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if stat.ContentType != info.ContentType || stat.CacheControl != info.CacheControl || stat.Size != 4 ||
+		stat.Metadata["source-hash"] != "0123" || !stat.LastModified.Equal(now) {
+		t.Fatalf("unexpected info %+v", stat)
+	}
+	if _, err := store.Stat("missing.jpg"); err != storage.ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if exists, err := store.Exists("missing.jpg"); err != nil || exists {
+		t.Fatalf("expected a missing image, got %v: %v", exists, err)
+	}
+
+	if keys, err := store.List(now.Add(time.Minute)); err != nil || len(keys) != 1 || keys[0] != "elon.jpg" {
+		t.Fatalf("expected elon.jpg to be listed, got %v: %v", keys, err)
+	}
+	if keys, err := store.List(now); err != nil || len(keys) != 0 {
+		t.Fatalf("expected nothing modified before %v, got %v: %v", now, keys, err)
+	}
+
+	url, err := store.URL("elon.jpg")
+	if err != nil || !strings.HasPrefix(url, server.URL+"/"+DefaultBucket+"/faces/elon.jpg?") {
+		t.Fatalf("unexpected url %q: %v", url, err)
+	}
+
+	if err := store.Delete("elon.jpg"); err != nil {
+		t.Fatalf("error: %v", err)
 	}
-	if strings.Contains(wanted, expected) {
-		t.Fail()
+	if exists, err := store.Exists("elon.jpg"); err != nil || exists {
+		t.Fatalf("expected the image to be deleted, got %v: %v", exists, err)
 	}
 }
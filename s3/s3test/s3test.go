@@ -0,0 +1,177 @@
+package s3test
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metadataPrefix = "X-Amz-Meta-"
+
+// Server is an s3 compatible http server keeping the objects in memory. It
+// serves path style requests, i.e. Endpoint set to URL and PathStyle enabled,
+// and ignores the signatures.
+type Server struct {
+	URL string
+
+	server  *httptest.Server
+	mutex   sync.Mutex
+	objects map[string]*Object
+	now     func() time.Time
+}
+
+// Object is a stored object
+type Object struct {
+	Body         []byte
+	Header       http.Header
+	LastModified time.Time
+}
+
+type listResult struct {
+	XMLName     xml.Name        `xml:"ListBucketResult"`
+	Name        string          `xml:"Name"`
+	Prefix      string          `xml:"Prefix"`
+	KeyCount    int             `xml:"KeyCount"`
+	IsTruncated bool            `xml:"IsTruncated"`
+	Contents    []listedContent `xml:"Contents"`
+}
+
+type listedContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// NewServer starts a server on a random local port
+func NewServer() *Server {
+	server := &Server{objects: make(map[string]*Object), now: time.Now}
+	server.server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	server.URL = server.server.URL
+	return server
+}
+
+// Close stops the server
+func (server *Server) Close() {
+	server.server.Close()
+}
+
+// SetNow replaces the clock setting the modification time of the objects
+func (server *Server) SetNow(now func() time.Time) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.now = now
+}
+
+// Object returns the object stored in bucket under key
+func (server *Server) Object(bucket string, key string) (*Object, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	object, found := server.objects[bucket+"/"+key]
+	return object, found
+}
+
+func (server *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := strings.TrimPrefix(r.URL.Path, "/"), ""
+	if slash := strings.Index(bucket, "/"); slash >= 0 {
+		bucket, key = bucket[:slash], bucket[slash+1:]
+	}
+	if bucket == "" {
+		writeError(w, http.StatusBadRequest, "InvalidBucketName")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		server.list(w, bucket, r.URL.Query().Get("prefix"))
+	case key == "":
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	case r.Method == http.MethodPut:
+		server.put(w, r, bucket+"/"+key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		server.get(w, r, bucket+"/"+key)
+	case r.Method == http.MethodDelete:
+		server.mutex.Lock()
+		delete(server.objects, bucket+"/"+key)
+		server.mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (server *Server) put(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	header := make(http.Header)
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, metadataPrefix) || name == "Content-Type" || name == "Cache-Control" {
+			header[name] = values
+		}
+	}
+	server.mutex.Lock()
+	server.objects[name] = &Object{Body: body, Header: header, LastModified: server.now().UTC()}
+	server.mutex.Unlock()
+	w.Header().Set("ETag", `"`+strconv.Itoa(len(body))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (server *Server) get(w http.ResponseWriter, r *http.Request, name string) {
+	server.mutex.Lock()
+	object, found := server.objects[name]
+	server.mutex.Unlock()
+	if !found {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	for name, values := range object.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.Body)))
+	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(object.Body)
+	}
+}
+
+func (server *Server) list(w http.ResponseWriter, bucket string, prefix string) {
+	result := listResult{Name: bucket, Prefix: prefix}
+	server.mutex.Lock()
+	for name, object := range server.objects {
+		if key := strings.TrimPrefix(name, bucket+"/"); key != name && strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, listedContent{
+				Key:          key,
+				LastModified: object.LastModified.Format(time.RFC3339),
+				Size:         len(object.Body),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	server.mutex.Unlock()
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header + "<Error><Code>" + code + "</Code></Error>"))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	models "github.com/rohith2506/facedetect/models"
+	storage "github.com/rohith2506/facedetect/storage"
+	utilities "github.com/rohith2506/facedetect/utilities"
+	video "github.com/rohith2506/facedetect/video"
+)
+
+// The frames of a sequence are detected one after the other while the request
+// waits, and the answer must be written within the server write timeout
+const (
+	maxSequenceSize    = 32 << 20 // 32 MiB
+	maxSequenceFrames  = 20
+	maxSequencePixels  = maxSequenceFrames * 1920 * 1080 // full hd frames
+	defaultSequenceFPS = 10
+	defaultSampleRate  = 1
+	contactSheetSuffix = "_sheet.jpg"
+)
+
+var sequenceExtensions = []string{".gif", ".mjpeg", ".mjpg", ".avi"}
+
+// sequenceLimits are checked against the headers of the uploaded frames before they are decoded
+var sequenceLimits = video.Limits{MaxFrames: maxSequenceFrames, MaxPixels: maxSequencePixels}
+
+// isSequenceUpload tells whether the uploaded files form a sequence of frames
+// rather than a single still image
+func isSequenceUpload(files []*multipart.FileHeader) bool {
+	if len(files) > 1 {
+		return true
+	}
+	_, found := utilities.Find(sequenceExtensions, strings.ToLower(filepath.Ext(files[0].Filename)))
+	return found
+}
+
+// getPositiveFloat reads an optional positive number from the form
+func getPositiveFloat(c *gin.Context, field string, defaultValue float64) (float64, error) {
+	raw := c.PostForm(field)
+	if raw == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number", field)
+	}
+	return value, nil
+}
+
+func readUploadedFile(file *multipart.FileHeader) ([]byte, error) {
+	upload, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer upload.Close()
+	return ioutil.ReadAll(upload)
+}
+
+// readSequence decodes the sampled frames of the uploaded files. A single file
+// is a gif, a motion jpeg or a motion jpeg avi, several files are the frames
+// of an image sequence, ordered by name. Both motion jpeg and image sequences
+// are spaced according to the "fps" form value. It returns the hash of the
+// upload, and the http status to answer with when the upload is rejected.
+func readSequence(c *gin.Context, files []*multipart.FileHeader, sampleRate float64) ([]video.Frame, string, int, error) {
+	fps, err := getPositiveFloat(c, "fps", defaultSequenceFPS)
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.Size
+	}
+	if totalSize > maxSequenceSize {
+		return nil, "", http.StatusRequestEntityTooLarge, fmt.Errorf("sequences are limited to %d bytes", maxSequenceSize)
+	}
+
+	var frames []video.Frame
+	if len(files) == 1 {
+		extension := strings.ToLower(filepath.Ext(files[0].Filename))
+		data, err := readUploadedFile(files[0])
+		if err != nil {
+			return nil, "", http.StatusBadRequest, err
+		}
+		switch extension {
+		case ".gif":
+			frames, err = video.DecodeGIF(data, sampleRate, sequenceLimits)
+		case ".mjpeg", ".mjpg":
+			frames, err = video.DecodeMJPEG(data, fps, sampleRate, sequenceLimits)
+		case ".avi":
+			frames, err = video.DecodeAVI(data, sampleRate, sequenceLimits)
+		default:
+			err = errors.New("possible extensions are [gif, mjpeg, mjpg, avi]")
+		}
+		if err != nil {
+			return nil, "", http.StatusBadRequest, explainLimit(err)
+		}
+		return frames, utilities.GetBytesHash(data), http.StatusOK, nil
+	}
+
+	sorted := append([]*multipart.FileHeader{}, files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Filename < sorted[j].Filename
+	})
+	images := make([][]byte, 0, len(sorted))
+	for _, file := range sorted {
+		if _, found := utilities.Find(availableExtensions, strings.ToLower(filepath.Ext(file.Filename))); !found {
+			return nil, "", http.StatusBadRequest, errors.New("image sequences are made of [jpg, jpeg, png] files")
+		}
+		data, err := readUploadedFile(file)
+		if err != nil {
+			return nil, "", http.StatusBadRequest, err
+		}
+		images = append(images, data)
+	}
+	frames, err = video.DecodeImages(images, fps, sampleRate, sequenceLimits)
+	if err != nil {
+		return nil, "", http.StatusBadRequest, explainLimit(err)
+	}
+	// the frames are hashed in the order they are played
+	return frames, utilities.GetBytesHash(bytes.Join(images, nil)), http.StatusOK, nil
+}
+
+// explainLimit tells how to fit a sequence rejected for its number of frames within the limits
+func explainLimit(err error) error {
+	if err == video.ErrTooManyFrames {
+		return fmt.Errorf("%v, at most %d frames are detected per request, lower the sample_rate or split the sequence", err, maxSequenceFrames)
+	}
+	return err
+}
+
+// uploadContactSheet renders the timeline as a contact sheet, puts it in the image store and returns its url.
+// The sheet is stored and tagged under the hash of the upload, so that it is erased and expires along with it.
+func (app *App) uploadContactSheet(uploadHash string, frames []video.Frame, timeline []SequenceFrame) (string, error) {
+	images := make([]image.Image, len(frames))
+	faces := make([][]models.Detection, len(frames))
+	labels := make([]string, len(frames))
+	for i, frame := range frames {
+		images[i] = frame.Image
+		faces[i] = timeline[i].Landmarks
+		labels[i] = fmt.Sprintf("#%d  %.2fs  %d faces", frame.Index, frame.Timestamp.Seconds(), len(timeline[i].Landmarks))
+	}
+
+	sheet := new(bytes.Buffer)
+	if err := models.RenderContactSheet(images, faces, labels, sheet); err != nil {
+		return "", err
+	}
+
+	faceCount := 0
+	for _, frame := range timeline {
+		faceCount += len(frame.Landmarks)
+	}
+	sheetName := uploadHash + contactSheetSuffix
+	err := app.Store.Put(sheetName, sheet, storage.ImageInfo{
+		ContentType: "image/jpeg",
+		Metadata: map[string]string{
+			storage.MetaSourceHash: uploadHash,
+			storage.MetaModel:      models.MTCNNName,
+			storage.MetaFaceCount:  strconv.Itoa(faceCount),
+			storage.MetaCreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return app.Store.URL(sheetName)
+}
+
+// handleSequenceUpload runs the detection on the frames sampled from a video
+// or an image sequence, about "sample_rate" frames per second, and answers
+// with the timeline of the detections and an annotated contact sheet
+func (app *App) handleSequenceUpload(c *gin.Context, start time.Time, files []*multipart.FileHeader) {
+	sampleRate, err := getPositiveFloat(c, "sample_rate", defaultSampleRate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid sample rate": err.Error()})
+		return
+	}
+
+	frames, uploadHash, status, err := readSequence(c, files, sampleRate)
+	if err != nil {
+		c.JSON(status, gin.H{"sequence decoding failed": err.Error()})
+		return
+	}
+
+	timeline, uniqueFaces, err := app.trackFrames(frames)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"frame detection failed": err.Error()})
+		return
+	}
+
+	sheetURL, err := app.uploadContactSheet(uploadHash, frames, timeline)
+	if err != nil {
+		log.Printf("Error in creating the contact sheet: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"contact sheet creation failed": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timeline":          timeline,
+		"unique_faces":      uniqueFaces,
+		"contact_sheet_url": sheetURL,
+		"time_took":         time.Since(start).Milliseconds(),
+	})
+}
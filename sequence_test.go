@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	storage "github.com/rohith2506/facedetect/storage"
+	utilities "github.com/rohith2506/facedetect/utilities"
+)
+
+func encodeTestGIF(t *testing.T, frames int) []byte {
+	animation := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 64, 64), palette.Plan9))
+		animation.Delay = append(animation.Delay, 100)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, animation); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func performSequenceRequest(r http.Handler, filename string, data []byte) *httptest.ResponseRecorder {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	w, _ := mw.CreateFormFile("file", filename)
+	w.Write(data)
+	mw.Close()
+	req, _ := http.NewRequest("POST", "/upload", buf)
+	req.Header.Add("Content-Type", mw.FormDataContentType())
+	w2 := httptest.NewRecorder()
+	r.ServeHTTP(w2, req)
+	return w2
+}
+
+func TestSequenceContactSheet(t *testing.T) {
+	app := newTestApp(t)
+	app.AdminToken = "secret"
+	router := SetupRouter(app)
+	data := encodeTestGIF(t, 2)
+	w := performSequenceRequest(router, "clip.gif", data)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the sheet belongs to the upload, so it is erased along with it
+	uploadHash := utilities.GetBytesHash(data)
+	info, err := app.Store.Stat(uploadHash + contactSheetSuffix)
+	if err != nil || info.Metadata[storage.MetaSourceHash] != uploadHash {
+		t.Fatalf("expected the sheet to be stored under the upload hash, got %+v: %v", info, err)
+	}
+	w = performAdminRequest(router, "DELETE", "/v1/images/"+uploadHash, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if exists, _ := app.Store.Exists(uploadHash + contactSheetSuffix); exists {
+		t.Fatal("expected the sheet to be erased")
+	}
+
+	w = performSequenceRequest(router, "long.gif", encodeTestGIF(t, maxSequenceFrames+1))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,188 +1,367 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"io"
+	"flag"
+	"fmt"
+	"image"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	static "github.com/gin-gonic/contrib/static"
 	"github.com/gin-gonic/gin"
+	cache "github.com/rohith2506/facedetect/cache"
+	config "github.com/rohith2506/facedetect/config"
+	events "github.com/rohith2506/facedetect/events"
 	models "github.com/rohith2506/facedetect/models"
-	redis "github.com/rohith2506/facedetect/redis"
-	"github.com/rohith2506/facedetect/s3"
 	utilities "github.com/rohith2506/facedetect/utilities"
 )
 
+// The uploaded images are limited by their size, and by their dimensions before being decoded
 const (
-	tempDir     = "/tmp/images/"
-	redisDB     = 0
-	environment = "default"
-	bucket      = "facedetection25"
+	maxImageSize   = 8 << 20  // 8 MiB
+	maxImagePixels = 40 << 20 // about 40 megapixels
 )
 
-var redisConn *redis.Connection
-
-// RedisOutput ...
+// RedisOutput is the cached detection result. The cache holds the object key of the
+// rendered image, the url is signed again every time the result is served. The size
+// of the source image allows to rescale the landmarks for near duplicates.
 type RedisOutput struct {
 	Landmarks []models.Detection
-	ImageURL  string
+	ImageKey  string
+	ImageURL  string `json:"-"`
+	Width     int
+	Height    int
 }
 
 var (
 	availableExtensions = []string{".jpeg", ".jpg", ".png"}
+	errInvalidImage     = errors.New("invalid image")
 )
 
-// SetupRouter setups the default gin router
-func SetupRouter() *gin.Engine {
+// SetupRouter setups the default gin router serving the handlers of app
+func SetupRouter(app *App) *gin.Engine {
 	router := gin.Default()
-	router.MaxMultipartMemory = 8 << 20 // 8 MiB
+	router.MaxMultipartMemory = app.Config.Server.MaxMultipartMemory
 	router.Use(static.Serve("/", static.LocalFile("./templates", true)))
 
-	router.POST("/upload", ImageUploadHandler)
-	router.POST("/submit", ImagePostHandler)
+	router.POST("/upload", app.ImageUploadHandler)
+	router.POST("/submit", app.ImagePostHandler)
+	router.POST("/upload/zip", app.ZipUploadHandler)
+
+	router.GET(localImagesPath+"/:key", app.LocalImageHandler)
+
+	v1 := router.Group("/v1")
+	v1.POST("/jobs", app.JobSubmitHandler)
+	v1.GET("/jobs/:id", app.JobStatusHandler)
+	v1.GET("/jobs/:id/deliveries", app.JobDeliveriesHandler)
+	v1.POST("/events", app.EventsOpenHandler)
+	v1.GET("/events/:id", app.EventsHandler)
+	v1.GET("/stream", app.StreamHandler)
+	v1.POST("/track", app.TrackHandler)
+	v1.GET("/images/:hash", app.ImageMetadataHandler)
+	v1.DELETE("/images/:hash", app.requireAdmin, app.ImageDeleteHandler)
+
+	admin := v1.Group("/admin", app.requireAdmin)
+	admin.GET("/cache/stats", app.CacheStatsHandler)
+	admin.GET("/cache/images/:hash", app.CacheEntryHandler)
+	admin.DELETE("/cache", app.CachePurgeHandler)
+	admin.DELETE("/cache/models/:model/:version", app.ModelCacheDeleteHandler)
 
 	return router
 }
 
 // Main function
 func main() {
-	router := SetupRouter()
-	redisConn = redis.CreateConnection(redisDB)
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := flags.Bool("print-config", false, "print the effective configuration and exit")
+	settings, err := config.Load(flags, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if *printConfig {
+		if err := settings.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	app, err := NewApp(settings)
+	if err != nil {
+		log.Fatalf("Error in starting the application: %v", err)
+	}
+	if app.Retention > 0 {
+		app.startJanitor()
+	}
 	s := &http.Server{
-		Addr:           ":8000",
-		Handler:        router,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+		Addr:           settings.Server.Addr,
+		Handler:        SetupRouter(app),
+		ReadTimeout:    settings.Server.ReadTimeout,
+		WriteTimeout:   settings.Server.WriteTimeout,
+		MaxHeaderBytes: settings.Server.MaxHeaderBytes,
 	}
 	s.ListenAndServe()
 }
 
-// Checks whether the image already exists in codebase
-func getExistingImage(imageHash string) (*RedisOutput, error) {
+// Checks whether the detection cached under key already exists
+func (app *App) getExistingImage(key string) (*RedisOutput, error) {
 	var output *RedisOutput
 
-	if redisConn == nil {
-		redisConn = redis.CreateConnection(redisDB)
+	// Get the value from the cache
+	value, err := app.Cache.Get(key)
+	if err == cache.ErrNotFound {
+		// There is no existing key present. Just return nil
+		return output, nil
+	} else if err != nil {
+		app.stats.Error()
+		return output, err
 	}
 
-	// Get the value from redis
-	value, err := redisConn.GetKey(imageHash)
-	if err != nil {
+	// Parse the value to custom struct
+	if err := json.Unmarshal(value, &output); err != nil {
+		app.stats.Error()
 		return output, err
 	}
 
-	// There is no existing key present. Just return nil
-	if len(value) == 0 {
-		return output, nil
+	// Entries written before the object key was cached only hold an expiring url
+	if output.ImageKey == "" {
+		return nil, nil
 	}
+	return output, nil
+}
 
-	// Parse the value to custom struct
-	if err := json.Unmarshal([]byte(value), &output); err != nil {
-		return output, err
+// getSignedDetection returns the detection cached under key with a freshly signed image url, or nil
+func (app *App) getSignedDetection(key string) *RedisOutput {
+	output, err := app.getExistingImage(key)
+	if err != nil {
+		log.Printf("Cache get failed: %v", err)
 	}
-	return output, nil
+	if output == nil {
+		return nil
+	}
+	output.ImageURL, err = app.Store.URL(output.ImageKey)
+	if err != nil {
+		log.Printf("Signing cached image url failed: %v", err)
+		return nil
+	}
+	return output
 }
 
-// create the temporary image
-func createTempFile(multipartFile *multipart.FileHeader, response *http.Response, imagePath string) error {
-	if multipartFile == nil && response == nil {
-		return errors.New("Both Upload and URL retrieval are empty")
+// readImage reads the uploaded file into memory, urls are fetched by detectURL
+func readImage(multipartFile *multipart.FileHeader) ([]byte, error) {
+	if multipartFile.Size > maxImageSize {
+		return nil, utilities.ErrTooLarge
 	}
-	file, err := os.Create(imagePath)
-	defer file.Close()
-	if multipartFile == nil {
-		_, err = io.Copy(file, response.Body)
-	} else {
-		temp, _ := multipartFile.Open()
-		_, err = io.Copy(file, temp)
+	upload, err := multipartFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer upload.Close()
+	return utilities.ReadAllLimited(upload, maxImageSize)
+}
+
+// decodeImage decodes an uploaded or fetched image. Its dimensions are read from its header
+// first, a small file may declare an image which would take gigabytes once decoded.
+func decodeImage(imageData []byte) (image.Image, error) {
+	header, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	if int64(header.Width)*int64(header.Height) > maxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d is more than %d pixels", errInvalidImage, header.Width, header.Height, maxImagePixels)
 	}
+	img, _, err := image.Decode(bytes.NewReader(imageData))
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %v", errInvalidImage, err)
 	}
-	return nil
+	return img, nil
 }
 
-func handleFaceDetection(tempImage string, c *gin.Context, start time.Time, imageExtension string) {
-	// get the image hash
-	imageHash, err := utilities.GetImageHash(tempImage)
+// detectFaces runs the detection pipeline for the encoded image, answering
+// from the cache when the same image has been seen before. Every stage of the
+// pipeline is published to progress, which may be nil.
+func (app *App) detectFaces(imageData []byte, imageExtension string, progress events.Reporter) (*RedisOutput, error) {
+	// make sure this is an image we are able to decode
+	img, err := decodeImage(imageData)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"image hash generation failed": err.Error()})
-		return
+		return nil, err
 	}
+	return app.detectDecoded(imageData, img, imageExtension, progress)
+}
+
+// detectDecoded runs the detection pipeline for an image already decoded by decodeImage
+func (app *App) detectDecoded(imageData []byte, img image.Image, imageExtension string, progress events.Reporter) (*RedisOutput, error) {
+	bounds := img.Bounds()
+	progress.Report(events.StageDecoded, gin.H{"width": bounds.Dx(), "height": bounds.Dy()})
+
+	// get the image hash
+	imageHash := utilities.GetBytesHash(imageData)
 
 	// Find whether there is an existing image or not
-	cacheOutput, err := getExistingImage(imageHash)
+	options := renderOptions{
+		Style:     models.StyleAnnotated,
+		Extension: strings.ToLower(imageExtension),
+	}
+	cacheKey := app.detectionKey(imageHash, options)
+	if cacheOutput := app.getSignedDetection(cacheKey); cacheOutput != nil {
+		app.stats.Hit()
+		progress.Report(events.StageCacheHit, nil)
+		return cacheOutput, nil
+	}
+	app.stats.Miss()
+
+	// Concurrent requests for the same image share a single detection
+	result, shared, err := app.detections.Do(cacheKey, func() (interface{}, error) {
+		return app.runDetection(imageData, img, imageHash, imageExtension, options, progress)
+	})
 	if err != nil {
-		log.Printf("Redis get failed: %v", err)
+		return nil, err
+	}
+	output := *result.(*RedisOutput)
+	if shared {
+		app.stats.Coalesced()
+		progress.Report(events.StageCacheHit, gin.H{"coalesced": true})
 	}
+	return &output, nil
+}
 
-	// Return from cache
-	if cacheOutput != nil {
-		elapsed := time.Since(start)
-		c.JSON(http.StatusOK, gin.H{
-			"landmarks": cacheOutput.Landmarks,
-			"image_url": cacheOutput.ImageURL,
-			"time_took": elapsed.Milliseconds(),
-		})
+// runDetection detects the faces on the image, renders and stores the result and caches it.
+// Across instances, only the holder of the detection lock runs it while the others wait for the cached result.
+func (app *App) runDetection(imageData []byte, img image.Image, imageHash string, imageExtension string, options renderOptions, progress events.Reporter) (*RedisOutput, error) {
+	cacheKey := app.detectionKey(imageHash, options)
+	release, err := app.lockDetection(cacheKey)
+	if err != nil {
+		log.Printf("Detection lock failed: %v", err)
+	} else if release == nil {
+		if output := app.waitForDetection(cacheKey); output != nil {
+			app.stats.Coalesced()
+			progress.Report(events.StageCacheHit, gin.H{"coalesced": true})
+			return output, nil
+		}
 	} else {
-		// Run the algorithm
-		outputImageName := imageHash + filepath.Ext(imageExtension)
-		landmarks := models.RunFaceDetection(outputImageName, tempImage)
-
-		// get the image from s3
-		connection := s3.GetAwsSession(environment)
-		imageURL, err := connection.GetImageURL(outputImageName, bucket)
-		if err != nil {
-			log.Fatalf("Error in retrieving the image from aws s3: %v", err)
+		defer release()
+		// the previous holder may have finished in the meantime
+		if output := app.getSignedDetection(cacheKey); output != nil {
+			progress.Report(events.StageCacheHit, nil)
+			return output, nil
 		}
+	}
 
-		elapsed := time.Since(start)
-		c.JSON(http.StatusOK, gin.H{
-			"landmarks": landmarks,
-			"image_url": imageURL,
-			"time_took": elapsed.Milliseconds(),
-		})
-
-		// set the value in redis
-		redisOutput := RedisOutput{
-			Landmarks: landmarks,
-			ImageURL:  imageURL,
-		}
+	bounds := img.Bounds()
 
-		redisValue, err := json.Marshal(redisOutput)
-		if err != nil {
-			log.Fatalf("Error in creating json marshal for redis output: %v", err)
-		}
-		err = redisConn.SetKey(imageHash, redisValue)
-		if err != nil {
-			log.Printf("Error in redis set: %v", err)
+	// Reuse the detections of a near duplicate, such as a resized copy, before running the algorithm
+	outputImageName := imageHash + filepath.Ext(imageExtension)
+	perceptualHash := utilities.DHash(img)
+	landmarks, duplicateHash := app.findNearDuplicate(perceptualHash, imageHash, bounds, options)
+	if duplicateHash != "" {
+		app.stats.NearDuplicate()
+		progress.Report(events.StageCacheHit, gin.H{"near_duplicate": duplicateHash})
+		err = models.StoreRendering(imageHash, outputImageName, img, landmarks, app.Store, progress)
+	} else {
+		progress.Report(events.StageCacheMiss, nil)
+		landmarks, err = models.RunFaceDetection(app.Detector, imageHash, outputImageName, imageData, img, app.Store, progress)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// get the image url from the store
+	imageURL, err := app.Store.URL(outputImageName)
+	if err != nil {
+		return nil, err
+	}
+	progress.Report(events.StageUploaded, gin.H{"image_url": imageURL})
+
+	// set the value in the cache
+	redisOutput := &RedisOutput{
+		Landmarks: landmarks,
+		ImageKey:  outputImageName,
+		ImageURL:  imageURL,
+		Width:     bounds.Dx(),
+		Height:    bounds.Dy(),
+	}
+
+	redisValue, err := json.Marshal(redisOutput)
+	if err != nil {
+		log.Fatalf("Error in creating json marshal for redis output: %v", err)
+	}
+	err = app.Cache.Set(cacheKey, redisValue, app.detectionTTL())
+	if err != nil {
+		app.stats.Error()
+		log.Printf("Error in cache set: %v", err)
+	} else {
+		app.stats.Set()
+	}
+	err = app.Index.Add(imageHash, perceptualHash, app.detectionTTL())
+	if err != nil {
+		log.Printf("Error in indexing the perceptual hash: %v", err)
+	}
+	return redisOutput, nil
+}
+
+// detectionStatus returns the http status matching an error of the detection pipeline
+func detectionStatus(err error) int {
+	if errors.Is(err, errInvalidImage) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// handleFaceDetection answers with the outcome of detect
+func handleFaceDetection(c *gin.Context, start time.Time, progress events.Reporter, detect func() (*RedisOutput, error)) {
+	output, err := detect()
+	if err != nil {
+		progress.Report(events.StageFailed, gin.H{"error": err.Error()})
+		var failure *fetchFailure
+		if errors.As(err, &failure) {
+			c.JSON(fetchFailureResponse(failure.err))
+			return
 		}
+		c.JSON(detectionStatus(err), gin.H{"image processing failed": err.Error()})
+		return
 	}
 
-	// Delete the temp file
-	err = os.Remove(tempImage)
-	return
+	elapsed := time.Since(start)
+	result := gin.H{
+		"landmarks": output.Landmarks,
+		"image_url": output.ImageURL,
+		"time_took": elapsed.Milliseconds(),
+	}
+	progress.Report(events.StageDone, result)
+	c.JSON(http.StatusOK, result)
 }
 
-// ImageUploadHandler endpoint is responsible for handling uploaded images
-func ImageUploadHandler(c *gin.Context) {
+// ImageUploadHandler endpoint is responsible for handling uploaded images. Videos and
+// image sequences (several files) are sampled and answered with a timeline of detections.
+func (app *App) ImageUploadHandler(c *gin.Context) {
 	start := time.Now()
+	progress, err := app.getProgressReporter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid progress id": err.Error()})
+		return
+	}
+	progress.Report(events.StageReceived, nil)
+
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"invalid input file": err.Error()})
 		return
 	}
 
-	// temporary image
-	uniqueImageID := utilities.RandStringBytes()
+	// Videos and image sequences are sampled frame by frame
+	if files := c.Request.MultipartForm.File["file"]; isSequenceUpload(files) {
+		app.handleSequenceUpload(c, start, files)
+		return
+	}
+
 	imageExtension := filepath.Ext(file.Filename)
 	_, found := utilities.Find(availableExtensions, imageExtension)
 	if !found {
@@ -190,21 +369,29 @@ func ImageUploadHandler(c *gin.Context) {
 		return
 	}
 
-	// Create a temporary file
-	tempImage := tempDir + uniqueImageID + imageExtension
-	err = createTempFile(file, nil, tempImage)
+	// Read the image into memory
+	imageData, err := readImage(file)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"temporary file creation failed": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"invalid input file": err.Error()})
 		return
 	}
 
 	// Handle the face detection
-	handleFaceDetection(tempImage, c, start, imageExtension)
+	handleFaceDetection(c, start, progress, func() (*RedisOutput, error) {
+		return app.detectFaces(imageData, imageExtension, progress)
+	})
 }
 
 // ImagePostHandler endpoint is responsible for handling URL images
-func ImagePostHandler(c *gin.Context) {
+func (app *App) ImagePostHandler(c *gin.Context) {
 	start := time.Now()
+	progress, err := app.getProgressReporter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid progress id": err.Error()})
+		return
+	}
+	progress.Report(events.StageReceived, nil)
+
 	rawImageURL := c.PostForm("image_url")
 
 	imageURL, err := url.ParseRequestURI(rawImageURL)
@@ -221,23 +408,8 @@ func ImagePostHandler(c *gin.Context) {
 		return
 	}
 
-	// get the image from the URL
-	response, err := http.Get(rawImageURL)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"image fetch failed": err})
-		return
-	}
-	defer response.Body.Close()
-
-	// create the temporary image
-	uniqueImageID := utilities.RandStringBytes()
-	tempImage := tempDir + uniqueImageID + imageExtension
-	err = createTempFile(nil, response, tempImage)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"temporary file creation failed": err.Error()})
-		return
-	}
-
-	// Handle the face detection
-	handleFaceDetection(tempImage, c, start, imageExtension)
+	// Fetch the image from the URL and handle the face detection
+	handleFaceDetection(c, start, progress, func() (*RedisOutput, error) {
+		return app.detectURL(c.Request.Context(), rawImageURL, imageExtension, progress)
+	})
 }
@@ -0,0 +1,53 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	config "github.com/rohith2506/facedetect/config"
+)
+
+// newIntegrationApp connects to the services configured by the environment, as the server does
+func newIntegrationApp(t *testing.T) *App {
+	settings, err := config.Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, os.LookupEnv)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	app, err := NewApp(settings)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return app
+}
+
+const testImageURL = "https://i.dailymail.co.uk/1s/2020/01/03/11/22946918-7848133-image-a-66_1578049904813.jpg"
+
+func TestImagePostHandlerIntegration(t *testing.T) {
+	router := SetupRouter(newIntegrationApp(t))
+	w := performSubmitRequest(router, "POST", "/submit", testImageURL)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	response, err := ioutil.ReadAll(w.Body)
+	if err != nil || !strings.Contains(string(response), "\"bounds\":{") {
+		t.Fatalf("expected a face, got %s", response)
+	}
+}
+
+func TestImageUploadHandlerIntegration(t *testing.T) {
+	router := SetupRouter(newIntegrationApp(t))
+	w := performUploadRequest(router, "POST", "/upload")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	response, err := ioutil.ReadAll(w.Body)
+	if err != nil || !strings.Contains(string(response), wantedBounds) {
+		t.Fatalf("expected %s, got %s", wantedBounds, response)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"hash/crc32"
 	"io/ioutil"
 	"log"
 	"mime/multipart"
@@ -15,9 +17,12 @@ import (
 	"github.com/go-playground/assert/v2"
 )
 
-func performURLRequest(r http.Handler, method, path string) *httptest.ResponseRecorder {
+// the canned answer of the detector stand-in
+const wantedBounds = "{\"bounds\":{\"y\":909,\"x\":298,\"width\":705,\"height\":987}"
+
+func performSubmitRequest(r http.Handler, method, path string, imageURL string) *httptest.ResponseRecorder {
 	params := url.Values{}
-	params.Add("image_url", "https://i.dailymail.co.uk/1s/2020/01/03/11/22946918-7848133-image-a-66_1578049904813.jpg")
+	params.Add("image_url", imageURL)
 	req, _ := http.NewRequest(method, path, strings.NewReader(params.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Content-Length", strconv.Itoa(len(params.Encode())))
@@ -27,14 +32,18 @@ func performURLRequest(r http.Handler, method, path string) *httptest.ResponseRe
 }
 
 func TestImagePostHandler(t *testing.T) {
-	router := SetupRouter()
-	w := performURLRequest(router, "POST", "/submit")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "test_images/elon.jpg")
+	}))
+	defer upstream.Close()
+
+	router := SetupRouter(newTestApp(t))
+	w := performSubmitRequest(router, "POST", "/submit", upstream.URL+"/elon.jpg")
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	response, err := ioutil.ReadAll(w.Body)
-	wanted := "\"bounds\":{\"y\":83,\"x\":199,\"length\":87}"
-	if err != nil || strings.Index(string(response), wanted) < 0 {
-		t.Fail()
+	if err != nil || !strings.Contains(string(response), wantedBounds) {
+		t.Fatalf("expected %s, got %s", wantedBounds, response)
 	}
 }
 
@@ -56,13 +65,35 @@ func performUploadRequest(r http.Handler, method, path string) *httptest.Respons
 }
 
 func TestImageUploadHandler(t *testing.T) {
-	router := SetupRouter()
+	router := SetupRouter(newTestApp(t))
 	w := performUploadRequest(router, "POST", "/upload")
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	response, err := ioutil.ReadAll(w.Body)
-	wanted := "{\"bounds\":{\"y\":753,\"x\":1257,\"length\":986}"
-	if err != nil || strings.Index(string(response), wanted) < 0 {
-		t.Fail()
+	if err != nil || !strings.Contains(string(response), wantedBounds) {
+		t.Fatalf("expected %s, got %s", wantedBounds, response)
+	}
+}
+
+// encodePNGHeader returns the beginning of a png declaring an image of the given size
+func encodePNGHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 2 // 8 bit rgb
+	buf := bytes.NewBufferString("\x89PNG\r\n\x1a\n")
+	binary.Write(buf, binary.BigEndian, uint32(len(ihdr)-4))
+	buf.Write(ihdr)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestImageUploadHandlerPixelLimit(t *testing.T) {
+	router := SetupRouter(newTestApp(t))
+	w := performSequenceRequest(router, "huge.png", encodePNGHeader(100000, 100000))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	if !strings.Contains(w.Body.String(), "pixels") {
+		t.Fatalf("expected the image to be rejected for its dimensions, got %s", w.Body.String())
 	}
 }
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by the stores
+var (
+	ErrInvalidKey       = errors.New("invalid image key")
+	ErrInvalidSignature = errors.New("invalid or expired signature")
+	ErrNotFound         = errors.New("image not found")
+)
+
+// Metadata keys attached to the stored images
+const (
+	MetaSourceHash = "source-hash"
+	MetaModel      = "model"
+	MetaFaceCount  = "face-count"
+	MetaCreatedAt  = "created-at"
+)
+
+// DefaultURLExpiry is the lifetime of the image urls when IMAGE_URL_EXPIRY is not set
+const DefaultURLExpiry = 100 * time.Hour
+
+const metadataPrefix = ".meta-"
+
+var keyRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ImageInfo describes a stored image. ContentType, CacheControl and Metadata
+// are given to Put, Size and LastModified are filled in by Stat.
+type ImageInfo struct {
+	ContentType  string            `json:"content_type"`
+	CacheControl string            `json:"cache_control,omitempty"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"last_modified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// ImageStore keeps the rendered images and hands out urls to fetch them
+type ImageStore interface {
+	Put(key string, body io.Reader, info ImageInfo) error
+	URL(key string) (string, error)
+	Delete(key string) error
+	Exists(key string) (bool, error)
+	Stat(key string) (ImageInfo, error)
+	List(before time.Time) ([]string, error)
+}
+
+// LocalStore keeps the images in a directory on the local disk. The urls it
+// hands out are signed and expire, they are meant to be served by the
+// application itself through Open.
+type LocalStore struct {
+	dir     string
+	baseURL string
+	secret  []byte
+	expiry  time.Duration
+}
+
+// NewLocalStore creates the directory if needed. baseURL is the url under
+// which Open is served, the key is appended to it.
+func NewLocalStore(dir string, baseURL string, secret []byte, expiry time.Duration) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{
+		dir:     dir,
+		baseURL: baseURL,
+		secret:  secret,
+		expiry:  expiry,
+	}, nil
+}
+
+func (store *LocalStore) path(key string) (string, error) {
+	if !keyRe.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(store.dir, key), nil
+}
+
+// metadataPath returns the path of the sidecar file holding the image info.
+// Keys never start with a dot, so it cannot clash with an image.
+func (store *LocalStore) metadataPath(key string) string {
+	return filepath.Join(store.dir, metadataPrefix+key+".json")
+}
+
+// writeFile writes to a temporary file first, so that readers never see a partial file
+func (store *LocalStore) writeFile(filePath string, body io.Reader) error {
+	file, err := ioutil.TempFile(store.dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+// Put stores the image along with a sidecar file holding its info
+func (store *LocalStore) Put(key string, body io.Reader, info ImageInfo) error {
+	imagePath, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	metadata, err := json.Marshal(ImageInfo{
+		ContentType:  info.ContentType,
+		CacheControl: info.CacheControl,
+		Metadata:     info.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+	if err := store.writeFile(imagePath, body); err != nil {
+		return err
+	}
+	return store.writeFile(store.metadataPath(key), bytes.NewReader(metadata))
+}
+
+// Stat ...
+func (store *LocalStore) Stat(key string) (ImageInfo, error) {
+	var info ImageInfo
+	imagePath, err := store.path(key)
+	if err != nil {
+		return info, err
+	}
+	stat, err := os.Stat(imagePath)
+	if os.IsNotExist(err) {
+		return info, ErrNotFound
+	} else if err != nil {
+		return info, err
+	}
+
+	// Images stored without a sidecar file only have a size and a date
+	metadata, err := ioutil.ReadFile(store.metadataPath(key))
+	if err == nil {
+		err = json.Unmarshal(metadata, &info)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return info, err
+	}
+	info.Size = stat.Size()
+	info.LastModified = stat.ModTime().UTC()
+	return info, nil
+}
+
+// sign returns the signature of the key valid until expires
+func (store *LocalStore) sign(key string, expires string) string {
+	mac := hmac.New(sha256.New, store.secret)
+	mac.Write([]byte(key))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// URL ...
+func (store *LocalStore) URL(key string) (string, error) {
+	if !keyRe.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	expires := strconv.FormatInt(time.Now().Add(store.expiry).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", store.sign(key, expires))
+	return store.baseURL + "/" + key + "?" + query.Encode(), nil
+}
+
+// Verify checks the signature of a url handed out by URL
+func (store *LocalStore) Verify(key string, expires string, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(store.sign(key, expires))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Open returns the stored image
+func (store *LocalStore) Open(key string) (*os.File, error) {
+	imagePath, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(imagePath)
+}
+
+// Delete ...
+func (store *LocalStore) Delete(key string) error {
+	imagePath, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(store.metadataPath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(imagePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Exists ...
+func (store *LocalStore) Exists(key string) (bool, error) {
+	imagePath, err := store.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(imagePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the keys of the images last modified before the given time
+func (store *LocalStore) List(before time.Time) ([]string, error) {
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, file := range files {
+		if file.IsDir() || !keyRe.MatchString(file.Name()) || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		if file.ModTime().Before(before) {
+			keys = append(keys, file.Name())
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createTestStore(t *testing.T) *LocalStore {
+	dir, err := ioutil.TempDir("", "facedetect-store")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store, err := NewLocalStore(dir, "/images", []byte("secret"), time.Hour)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return store
+}
+
+func TestLocalStore(t *testing.T) {
+	store := createTestStore(t)
+	info := ImageInfo{ContentType: "image/jpeg", Metadata: map[string]string{MetaFaceCount: "1"}}
+	if err := store.Put("elon.jpg", strings.NewReader("jpeg"), info); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if found, err := store.Exists("elon.jpg"); !found || err != nil {
+		t.Fatalf("expected the image to exist: %v", err)
+	}
+
+	stat, err := store.Stat("elon.jpg")
+	if err != nil || stat.ContentType != "image/jpeg" || stat.Size != 4 || stat.Metadata[MetaFaceCount] != "1" {
+		t.Fatalf("unexpected info %+v: %v", stat, err)
+	}
+
+	if err := store.Delete("elon.jpg"); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if found, _ := store.Exists("elon.jpg"); found {
+		t.Fatalf("expected the image to be deleted")
+	}
+	if _, err := store.Stat("elon.jpg"); err != ErrNotFound {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+
+	if err := store.Put("../elon.jpg", strings.NewReader("jpeg"), info); err != ErrInvalidKey {
+		t.Fatalf("expected %v, got %v", ErrInvalidKey, err)
+	}
+}
+
+func TestLocalStoreSignedURL(t *testing.T) {
+	store := createTestStore(t)
+	rawURL, err := store.URL("elon.jpg")
+	if err != nil || !strings.HasPrefix(rawURL, "/images/elon.jpg?") {
+		t.Fatalf("unexpected url %s: %v", rawURL, err)
+	}
+
+	signedURL, _ := url.Parse(rawURL)
+	query := signedURL.Query()
+	if err := store.Verify("elon.jpg", query.Get("expires"), query.Get("signature")); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if err := store.Verify("me.png", query.Get("expires"), query.Get("signature")); err != ErrInvalidSignature {
+		t.Fatalf("expected %v, got %v", ErrInvalidSignature, err)
+	}
+	if err := store.Verify("elon.jpg", "1", store.sign("elon.jpg", "1")); err != ErrInvalidSignature {
+		t.Fatalf("expected expired signature, got %v", err)
+	}
+}
+
+func TestLocalStoreList(t *testing.T) {
+	store := createTestStore(t)
+	for _, key := range []string{"old.jpg", "new.jpg"} {
+		if err := store.Put(key, strings.NewReader("jpeg"), ImageInfo{ContentType: "image/jpeg"}); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(store.dir, "old.jpg"), past, past); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	keys, err := store.List(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "old.jpg" {
+		t.Fatalf("expected [old.jpg], got %v", keys)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	config "github.com/rohith2506/facedetect/config"
+	"github.com/rohith2506/facedetect/s3"
+	storage "github.com/rohith2506/facedetect/storage"
+)
+
+// Without a configured secret, the local image urls are signed with a random one
+const (
+	localImagesPath    = "/images"
+	localStoreKeyBytes = 32
+)
+
+var imageHashRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// newImageStore creates the configured image store, s3 being the default
+func newImageStore(settings *config.Config) (storage.ImageStore, error) {
+	if settings.Store.Backend == config.StoreLocal {
+		return newLocalStore(settings.Store)
+	}
+	conn, err := s3.NewConnection(settings.S3Config())
+	if err != nil {
+		return nil, fmt.Errorf("error in creating aws session: %v", err)
+	}
+	return s3.NewStore(conn), nil
+}
+
+func newLocalStore(settings config.StoreConfig) (*storage.LocalStore, error) {
+
+	secret := []byte(settings.LocalSecret)
+	if len(secret) == 0 {
+		log.Printf("LOCAL_STORE_SECRET is not set, image urls will not survive a restart")
+		secret = make([]byte, localStoreKeyBytes)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("error in generating the local store secret: %v", err)
+		}
+	}
+
+	return storage.NewLocalStore(settings.LocalDir, settings.PublicURL+localImagesPath, secret, settings.URLExpiry)
+}
+
+// LocalImageHandler endpoint serves the images of the local store to the holders of a valid signed url
+func (app *App) LocalImageHandler(c *gin.Context) {
+	store, ok := app.Store.(*storage.LocalStore)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"image not found": c.Param("key")})
+		return
+	}
+
+	key := c.Param("key")
+	if err := store.Verify(key, c.Query("expires"), c.Query("signature")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"access denied": err.Error()})
+		return
+	}
+
+	file, err := store.Open(key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"image not found": key})
+		return
+	}
+	defer file.Close()
+
+	info, err := store.Stat(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"image read failed": err.Error()})
+		return
+	}
+	if info.ContentType != "" {
+		c.Header("Content-Type", info.ContentType)
+	}
+	if info.CacheControl != "" {
+		c.Header("Cache-Control", info.CacheControl)
+	}
+	http.ServeContent(c.Writer, c.Request, key, info.LastModified, io.ReadSeeker(file))
+}
+
+// findImage returns the key and the info of the image rendered from the source image with the given hash
+func (app *App) findImage(imageHash string) (string, storage.ImageInfo, error) {
+	for _, extension := range availableExtensions {
+		key := imageHash + extension
+		info, err := app.Store.Stat(key)
+		if err != storage.ErrNotFound {
+			return key, info, err
+		}
+	}
+	return "", storage.ImageInfo{}, storage.ErrNotFound
+}
+
+// ImageMetadataHandler endpoint returns the metadata of the image rendered from the source image with the given hash
+func (app *App) ImageMetadataHandler(c *gin.Context) {
+	imageHash := c.Param("hash")
+	if !imageHashRe.MatchString(imageHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid image hash": imageHash})
+		return
+	}
+
+	key, info, err := app.findImage(imageHash)
+	if err == storage.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"image not found": imageHash})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"image lookup failed": err.Error()})
+		return
+	}
+
+	imageURL, err := app.Store.URL(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"image lookup failed": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"key":       key,
+		"image_url": imageURL,
+		"info":      info,
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	models "github.com/rohith2506/facedetect/models"
+	tracking "github.com/rohith2506/facedetect/tracking"
+)
+
+const (
+	maxFrameSize    = 2 << 20 // 2 MiB
+	streamPongWait  = 60 * time.Second
+	streamPingTime  = streamPongWait * 9 / 10
+	streamWriteWait = 10 * time.Second
+)
+
+var (
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+	errInvalidFrame = errors.New("frames must be jpeg images")
+)
+
+// FrameResult is sent back to the client for every processed frame
+type FrameResult struct {
+	Frame     int                `json:"frame"`
+	Landmarks []models.Detection `json:"landmarks"`
+	Dropped   int                `json:"dropped,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	TimeTook  int64              `json:"time_took"`
+}
+
+type streamFrame struct {
+	number  int
+	dropped int
+	data    []byte
+}
+
+// detectFrame runs the detector on a single jpeg frame
+func (app *App) detectFrame(data []byte) ([]models.Detection, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format != "jpeg" {
+		return nil, errInvalidFrame
+	}
+	return app.Detector.Detect(data)
+}
+
+// processFrames runs the detection on the queued frames and writes the results to the websocket.
+// The faces are tracked across the frames of the stream.
+func (app *App) processFrames(ws *websocket.Conn, frames <-chan streamFrame) {
+	tracker := tracking.NewTracker()
+	ticker := time.NewTicker(streamPingTime)
+	defer ticker.Stop()
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			start := time.Now()
+			landmarks, err := app.detectFrame(frame.data)
+			if err == nil {
+				landmarks = tracker.Update(landmarks)
+			}
+			result := FrameResult{
+				Frame:     frame.number,
+				Landmarks: landmarks,
+				Dropped:   frame.dropped,
+				TimeTook:  time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			ws.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := ws.WriteJSON(result); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// StreamHandler endpoint upgrades the connection to a websocket on which the
+// client sends jpeg frames as binary messages and receives the detections of
+// every frame as json, each face carrying a track_id stable across the frames.
+// Frames are neither cached nor uploaded. When frames
+// arrive faster than they are processed, only the latest one is kept.
+func (app *App) StreamHandler(c *gin.Context) {
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Websocket upgrade failed: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	// A single slot holding the most recent frame
+	frames := make(chan streamFrame, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.processFrames(ws, frames)
+	}()
+
+	ws.SetReadLimit(maxFrameSize)
+	ws.SetReadDeadline(time.Now().Add(streamPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+
+	number, dropped := 0, 0
+	for {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		ws.SetReadDeadline(time.Now().Add(streamPongWait))
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		number++
+		select {
+		case <-frames:
+			// Replace the stale frame still waiting for the detector
+			dropped++
+		default:
+		}
+		frames <- streamFrame{number: number, dropped: dropped, data: data}
+	}
+	close(frames)
+	<-done
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamHandlerRejectsInvalidFrames(t *testing.T) {
+	server := httptest.NewServer(SetupRouter(newTestApp(t)))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/stream", nil)
+	if err != nil {
+		t.Fatalf("error in dialing the websocket: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("not a jpeg")); err != nil {
+		t.Fatalf("error in writing the frame: %v", err)
+	}
+	var result FrameResult
+	if err := ws.ReadJSON(&result); err != nil {
+		t.Fatalf("error in reading the result: %v", err)
+	}
+	if result.Frame != 1 || result.Error == "" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
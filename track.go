@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	models "github.com/rohith2506/facedetect/models"
+	tracking "github.com/rohith2506/facedetect/tracking"
+	video "github.com/rohith2506/facedetect/video"
+)
+
+// SequenceFrame holds the tracked detections of a single frame
+type SequenceFrame struct {
+	Frame     int                `json:"frame"`
+	Timestamp int64              `json:"timestamp_ms"`
+	Landmarks []models.Detection `json:"landmarks"`
+}
+
+// detectImage encodes a decoded image as jpeg for the detector
+func (app *App) detectImage(img image.Image) ([]models.Detection, error) {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		return nil, err
+	}
+	return app.Detector.Detect(buf.Bytes())
+}
+
+// trackFrames detects the faces on every frame and follows them across the
+// frames. It returns the timeline together with the number of unique faces.
+func (app *App) trackFrames(frames []video.Frame) ([]SequenceFrame, int, error) {
+	tracker := tracking.NewTracker()
+	timeline := make([]SequenceFrame, 0, len(frames))
+	for _, frame := range frames {
+		landmarks, err := app.detectImage(frame.Image)
+		if err != nil {
+			return nil, 0, err
+		}
+		timeline = append(timeline, SequenceFrame{
+			Frame:     frame.Index,
+			Timestamp: frame.Timestamp.Milliseconds(),
+			Landmarks: tracker.Update(landmarks),
+		})
+	}
+	return timeline, tracker.UniqueFaces(), nil
+}
+
+// TrackHandler endpoint detects the faces on every frame of an uploaded gif,
+// motion jpeg or avi and follows them across the frames, giving every face a
+// track_id which stays the same for as long as the face is visible
+func (app *App) TrackHandler(c *gin.Context) {
+	start := time.Now()
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"invalid input file": "file is missing"})
+		return
+	}
+
+	frames, _, status, err := readSequence(c, form.File["file"], 0)
+	if err != nil {
+		c.JSON(status, gin.H{"sequence decoding failed": err.Error()})
+		return
+	}
+
+	timeline, uniqueFaces, err := app.trackFrames(frames)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"frame detection failed": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"frames":       timeline,
+		"unique_faces": uniqueFaces,
+		"time_took":    time.Since(start).Milliseconds(),
+	})
+}
@@ -0,0 +1,215 @@
+package tracking
+
+import (
+	"math"
+	"sort"
+
+	models "github.com/rohith2506/facedetect/models"
+)
+
+// Default tracker settings
+const (
+	DefaultMinIoU       = 0.2
+	DefaultMaxDistance  = 0.5
+	DefaultMaxMissed    = 5
+	velocitySmoothing   = 0.5
+	iouWeight           = 0.7
+	landmarkWeight      = 1 - iouWeight
+	minLandmarkDistance = 1e-9
+)
+
+type box struct {
+	x, y, width, height float64
+}
+
+func newBox(rect models.RectCoord) box {
+	return box{
+		x:      float64(rect.Row),
+		y:      float64(rect.Col),
+		width:  float64(rect.Width),
+		height: float64(rect.Height),
+	}
+}
+
+func (b box) centre() (float64, float64) {
+	return b.x + b.width/2, b.y + b.height/2
+}
+
+// iou returns the intersection over union of two boxes
+func iou(a box, b box) float64 {
+	left, top := math.Max(a.x, b.x), math.Max(a.y, b.y)
+	right, bottom := math.Min(a.x+a.width, b.x+b.width), math.Min(a.y+a.height, b.y+b.height)
+	if right <= left || bottom <= top {
+		return 0
+	}
+	intersection := (right - left) * (bottom - top)
+	union := a.width*a.height + b.width*b.height - intersection
+	if union <= 0 {
+		return 0
+	}
+	return intersection / union
+}
+
+type track struct {
+	id         int
+	box        box
+	vx, vy     float64
+	lastSeen   models.Detection
+	missed     int
+	hits       int
+	hasHistory bool
+}
+
+// predict moves the box of the track along its estimated velocity
+func (t *track) predict() box {
+	predicted := t.box
+	predicted.x += t.vx
+	predicted.y += t.vy
+	return predicted
+}
+
+// update moves the track to the matched detection, smoothing the velocity
+func (t *track) update(detection models.Detection) {
+	next := newBox(detection.FaceCoord)
+	cx, cy := t.box.centre()
+	nx, ny := next.centre()
+	if t.hasHistory {
+		t.vx = velocitySmoothing*(nx-cx) + (1-velocitySmoothing)*t.vx
+		t.vy = velocitySmoothing*(ny-cy) + (1-velocitySmoothing)*t.vy
+	} else {
+		t.vx, t.vy = nx-cx, ny-cy
+		t.hasHistory = true
+	}
+	t.box = next
+	t.lastSeen = detection
+	t.missed = 0
+	t.hits++
+}
+
+// landmarkDistance returns the mean distance between the landmarks of two
+// detections, the prediction offset removed and normalised by the face width
+func landmarkDistance(t *track, detection models.Detection) float64 {
+	previous := t.lastSeen
+	pairs := [][2]models.Coord{
+		{previous.LeftEye, detection.LeftEye},
+		{previous.RightEye, detection.RightEye},
+		{previous.Nose, detection.Nose},
+	}
+	for i := 0; i < len(previous.Mouth) && i < len(detection.Mouth); i++ {
+		pairs = append(pairs, [2]models.Coord{previous.Mouth[i], detection.Mouth[i]})
+	}
+
+	width := math.Max(t.box.width, minLandmarkDistance)
+	var total float64
+	for _, pair := range pairs {
+		dx := float64(pair[1].Row) - (float64(pair[0].Row) + t.vx)
+		dy := float64(pair[1].Col) - (float64(pair[0].Col) + t.vy)
+		total += math.Hypot(dx, dy)
+	}
+	return total / float64(len(pairs)) / width
+}
+
+type candidate struct {
+	track     int
+	detection int
+	score     float64
+}
+
+// Tracker assigns stable track ids to the detections of consecutive frames.
+// Tracks follow a constant velocity motion model and detections are matched
+// greedily on a mix of the box overlap and the landmark distance.
+type Tracker struct {
+	tracks []*track
+	nextID int
+
+	// MinIoU is the overlap with the predicted box needed to match a track
+	MinIoU float64
+	// MaxDistance is the landmark distance, relative to the face width, under which a detection matches a track
+	MaxDistance float64
+	// MaxMissed is the number of frames a track survives without any detection
+	MaxMissed int
+}
+
+// NewTracker ...
+func NewTracker() *Tracker {
+	return &Tracker{
+		nextID:      1,
+		MinIoU:      DefaultMinIoU,
+		MaxDistance: DefaultMaxDistance,
+		MaxMissed:   DefaultMaxMissed,
+	}
+}
+
+// Update matches the detections of the next frame with the current tracks and
+// returns them with their TrackID set. Unmatched detections start new tracks.
+func (tracker *Tracker) Update(detections []models.Detection) []models.Detection {
+	var candidates []candidate
+	for i, current := range tracker.tracks {
+		predicted := current.predict()
+		for j, detection := range detections {
+			overlap := iou(predicted, newBox(detection.FaceCoord))
+			distance := landmarkDistance(current, detection)
+			if overlap < tracker.MinIoU && distance > tracker.MaxDistance {
+				continue
+			}
+			closeness := math.Max(0, 1-distance/tracker.MaxDistance)
+			candidates = append(candidates, candidate{
+				track:     i,
+				detection: j,
+				score:     iouWeight*overlap + landmarkWeight*closeness,
+			})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	results := make([]models.Detection, len(detections))
+	copy(results, detections)
+	matchedTracks := make(map[int]bool)
+	matchedDetections := make(map[int]bool)
+	for _, match := range candidates {
+		if matchedTracks[match.track] || matchedDetections[match.detection] {
+			continue
+		}
+		matchedTracks[match.track] = true
+		matchedDetections[match.detection] = true
+		current := tracker.tracks[match.track]
+		current.update(detections[match.detection])
+		results[match.detection].TrackID = current.id
+	}
+
+	// Age the unmatched tracks, dropping the ones lost for too long
+	var alive []*track
+	for i, current := range tracker.tracks {
+		if !matchedTracks[i] {
+			current.missed++
+			current.box = current.predict()
+		}
+		if current.missed <= tracker.MaxMissed {
+			alive = append(alive, current)
+		}
+	}
+	tracker.tracks = alive
+
+	for j, detection := range detections {
+		if matchedDetections[j] {
+			continue
+		}
+		current := &track{
+			id:       tracker.nextID,
+			box:      newBox(detection.FaceCoord),
+			lastSeen: detection,
+			hits:     1,
+		}
+		tracker.nextID++
+		tracker.tracks = append(tracker.tracks, current)
+		results[j].TrackID = current.id
+	}
+	return results
+}
+
+// UniqueFaces returns the number of tracks created so far
+func (tracker *Tracker) UniqueFaces() int {
+	return tracker.nextID - 1
+}
@@ -0,0 +1,53 @@
+package tracking
+
+import (
+	"testing"
+
+	models "github.com/rohith2506/facedetect/models"
+)
+
+func face(x int, y int) models.Detection {
+	return models.Detection{
+		FaceCoord: models.RectCoord{Row: x, Col: y, Width: 100, Height: 120},
+		LeftEye:   models.Coord{Row: x + 30, Col: y + 40},
+		RightEye:  models.Coord{Row: x + 70, Col: y + 40},
+		Nose:      models.Coord{Row: x + 50, Col: y + 60},
+		Mouth:     []models.Coord{{Row: x + 35, Col: y + 90}, {Row: x + 65, Col: y + 90}},
+	}
+}
+
+func TestTrackerKeepsIDs(t *testing.T) {
+	tracker := NewTracker()
+	first := tracker.Update([]models.Detection{face(0, 0), face(400, 0)})
+	if first[0].TrackID != 1 || first[1].TrackID != 2 {
+		t.Fatalf("unexpected track ids: %d, %d", first[0].TrackID, first[1].TrackID)
+	}
+
+	// Both faces move, the order of the detections changes
+	second := tracker.Update([]models.Detection{face(420, 10), face(20, 5)})
+	if second[0].TrackID != 2 || second[1].TrackID != 1 {
+		t.Fatalf("unexpected track ids: %d, %d", second[0].TrackID, second[1].TrackID)
+	}
+
+	// The motion model still finds a face moving at a constant speed
+	third := tracker.Update([]models.Detection{face(80, 15)})
+	if third[0].TrackID != 1 {
+		t.Fatalf("unexpected track id: %d", third[0].TrackID)
+	}
+
+	fourth := tracker.Update([]models.Detection{face(800, 400)})
+	if fourth[0].TrackID != 3 || tracker.UniqueFaces() != 3 {
+		t.Fatalf("expected a new track, got %d", fourth[0].TrackID)
+	}
+}
+
+func TestTrackerDropsLostTracks(t *testing.T) {
+	tracker := NewTracker()
+	tracker.MaxMissed = 1
+	tracker.Update([]models.Detection{face(0, 0)})
+	tracker.Update(nil)
+	tracker.Update(nil)
+	if result := tracker.Update([]models.Detection{face(0, 0)}); result[0].TrackID != 2 {
+		t.Fatalf("expected a new track, got %d", result[0].TrackID)
+	}
+}
@@ -3,7 +3,11 @@ package utilities
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
+	"image"
 	"io"
+	"io/ioutil"
+	"math/bits"
 	"math/rand"
 	"os"
 )
@@ -12,8 +16,13 @@ const (
 	letterBytes  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	maxLength    = 30
 	maxHashBytes = 16
+	dHashCols    = 8
+	dHashRows    = 8
 )
 
+// ErrTooLarge is returned by ReadAllLimited when the data exceeds the limit
+var ErrTooLarge = errors.New("data exceeds the maximum size")
+
 // RandStringBytes ...
 func RandStringBytes() string {
 	b := make([]byte, maxLength)
@@ -51,3 +60,76 @@ func GetImageHash(imagePath string) (string, error) {
 	md5Hash = hex.EncodeToString(hashInBytes)
 	return md5Hash, nil
 }
+
+// GetBytesHash returns the md5 of the data, the in memory counterpart of GetImageHash
+func GetBytesHash(data []byte) string {
+	hashInBytes := md5.Sum(data)
+	return hex.EncodeToString(hashInBytes[:maxHashBytes])
+}
+
+// ReadAllLimited reads the whole reader into memory, failing with ErrTooLarge
+// as soon as more than limit bytes are found
+func ReadAllLimited(reader io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// DHash returns the 64 bit difference hash of the image. The image is reduced to
+// 9x8 cells of average brightness and every bit tells whether a cell is brighter
+// than its right neighbour, so re-encoded or resized copies get the same or a close hash.
+func DHash(img image.Image) uint64 {
+	var cells [dHashRows][dHashCols + 1]float64
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return 0
+	}
+	for row := 0; row < dHashRows; row++ {
+		minY, maxY := bounds.Min.Y+row*height/dHashRows, bounds.Min.Y+(row+1)*height/dHashRows
+		for col := 0; col <= dHashCols; col++ {
+			minX, maxX := bounds.Min.X+col*width/(dHashCols+1), bounds.Min.X+(col+1)*width/(dHashCols+1)
+			cells[row][col] = averageBrightness(img, minX, maxX, minY, maxY)
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < dHashRows; row++ {
+		for col := 0; col < dHashCols; col++ {
+			hash <<= 1
+			if cells[row][col] > cells[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// averageBrightness returns the mean luminance of the pixels inside the given area
+func averageBrightness(img image.Image, minX, maxX, minY, maxY int) float64 {
+	// images smaller than the grid still get one pixel per cell
+	if maxX <= minX {
+		maxX = minX + 1
+	}
+	if maxY <= minY {
+		maxY = minY + 1
+	}
+	var sum float64
+	for y := minY; y < maxY; y++ {
+		for x := minX; x < maxX; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+	return sum / float64((maxX-minX)*(maxY-minY))
+}
+
+// HammingDistance returns the number of bits which differ between the hashes
+func HammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -1,6 +1,16 @@
 package utilities
 
-import "testing"
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
 
 func TestFind(t *testing.T) {
 	inputArr := []string{"rohith", "uppala"}
@@ -17,3 +27,57 @@ func TestFind(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestReadAllLimited(t *testing.T) {
+	data, err := ReadAllLimited(strings.NewReader("face"), 4)
+	if err != nil || string(data) != "face" {
+		t.Fatalf("unexpected result %q: %v", data, err)
+	}
+	if _, err := ReadAllLimited(strings.NewReader("faces"), 4); err != ErrTooLarge {
+		t.Fatalf("expected %v, got %v", ErrTooLarge, err)
+	}
+}
+
+func TestGetBytesHash(t *testing.T) {
+	wanted, err := GetImageHash("utilities.go")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	data, _ := ioutil.ReadFile("utilities.go")
+	if got := GetBytesHash(data); got != wanted {
+		t.Fatalf("expected %s, got %s", wanted, got)
+	}
+}
+
+func TestDHash(t *testing.T) {
+	decode := func(name string) image.Image {
+		file, err := os.Open("../test_images/" + name)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer file.Close()
+		img, _, err := image.Decode(file)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return img
+	}
+	elon := decode("elon.jpg")
+
+	// a smaller, re-encoded copy of the same photo
+	encoded := new(bytes.Buffer)
+	if err := jpeg.Encode(encoded, resize.Resize(uint(elon.Bounds().Dx()/2), 0, elon, resize.Bilinear), &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	copied, err := jpeg.Decode(encoded)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	if distance := HammingDistance(DHash(elon), DHash(copied)); distance > 3 {
+		t.Fatalf("expected the copy to be a near duplicate, distance %d", distance)
+	}
+	if distance := HammingDistance(DHash(elon), DHash(decode("multiple_people.jpg"))); distance <= 10 {
+		t.Fatalf("expected different photos to be far apart, distance %d", distance)
+	}
+}
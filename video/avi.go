@@ -0,0 +1,93 @@
+package video
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidAVI is returned for files which are not motion jpeg avi files
+var ErrInvalidAVI = errors.New("invalid avi file")
+
+const defaultAVIFPS = 25
+
+// maxAVIDepth bounds the nesting of the lists, real files only nest a few of them
+const maxAVIDepth = 8
+
+// aviReader collects the jpeg frames and the frame rate of a riff avi file
+type aviReader struct {
+	frames              [][]byte
+	microSecondPerFrame uint32
+}
+
+// readChunks walks the riff chunks found in data, descending into the lists
+// down to maxAVIDepth so that a crafted file cannot exhaust the stack
+func (reader *aviReader) readChunks(data []byte, depth int) error {
+	if depth > maxAVIDepth {
+		return ErrInvalidAVI
+	}
+	for offset := 0; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		end := start + size
+		if size < 0 || end > len(data) {
+			// Truncated recordings are common, keep what was read so far
+			end = len(data)
+		}
+		payload := data[start:end]
+
+		switch {
+		case id == "RIFF" || id == "LIST":
+			if len(payload) < 4 {
+				return ErrInvalidAVI
+			}
+			if err := reader.readChunks(payload[4:], depth+1); err != nil {
+				return err
+			}
+		case id == "avih":
+			if len(payload) >= 4 {
+				reader.microSecondPerFrame = binary.LittleEndian.Uint32(payload[:4])
+			}
+		case len(id) == 4 && (id[2:] == "dc" || id[2:] == "db"):
+			// Compressed or uncompressed video frames, only jpeg is supported
+			if len(payload) > 2 && payload[0] == 0xFF && payload[1] == 0xD8 {
+				reader.frames = append(reader.frames, payload)
+			}
+		}
+
+		// chunks are padded to an even size
+		offset = end + size%2
+	}
+	return nil
+}
+
+// ReadAVI returns the jpeg frames of a motion jpeg avi file and its frame rate
+func ReadAVI(data []byte) ([][]byte, float64, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		return nil, 0, ErrInvalidAVI
+	}
+
+	reader := &aviReader{}
+	if err := reader.readChunks(data, 0); err != nil {
+		return nil, 0, err
+	}
+	if len(reader.frames) == 0 {
+		return nil, 0, ErrNoFrames
+	}
+
+	fps := float64(defaultAVIFPS)
+	if reader.microSecondPerFrame > 0 {
+		fps = 1e6 / float64(reader.microSecondPerFrame)
+	}
+	return reader.frames, fps, nil
+}
+
+// DecodeAVI returns the sampled frames of a motion jpeg avi file. A
+// sampleRate of 0 keeps every frame.
+func DecodeAVI(data []byte, sampleRate float64, limits Limits) ([]Frame, error) {
+	images, fps, err := ReadAVI(data)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeImages(images, fps, sampleRate, limits)
+}
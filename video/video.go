@@ -0,0 +1,283 @@
+package video
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg" // register the decoders used by DecodeImages
+	_ "image/png"
+	"time"
+)
+
+// Errors returned while reading the frames
+var (
+	ErrNoFrames      = errors.New("no frames found")
+	ErrTooManyFrames = errors.New("too many frames")
+	ErrTooManyPixels = errors.New("too many pixels")
+)
+
+// Limits bound the work of decoding a sequence. They are checked against the
+// headers of the frames, before anything is decoded.
+type Limits struct {
+	// MaxFrames is the largest number of sampled frames
+	MaxFrames int
+	// MaxPixels is the largest number of pixels decoded over the whole sequence
+	MaxPixels int64
+}
+
+// Frame is a single decoded frame of a sequence
+type Frame struct {
+	Index     int
+	Timestamp time.Duration
+	Image     image.Image
+}
+
+// sampler keeps about rate frames per second of a sequence
+type sampler struct {
+	interval time.Duration
+	next     time.Duration
+	started  bool
+}
+
+func newSampler(rate float64) *sampler {
+	if rate <= 0 {
+		return &sampler{}
+	}
+	return &sampler{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// keep tells whether the frame shown at timestamp is part of the sample
+func (s *sampler) keep(timestamp time.Duration) bool {
+	if s.started && timestamp < s.next {
+		return false
+	}
+	s.started = true
+	s.next = timestamp + s.interval
+	return true
+}
+
+// DecodeGIF returns the sampled frames of an animated gif, each one composed
+// on top of the previous ones as a browser would display it. A sampleRate of
+// 0 keeps every frame. Every frame is decoded to compose the sampled ones,
+// so all of them count towards the pixel limit.
+func DecodeGIF(data []byte, sampleRate float64, limits Limits) ([]Frame, error) {
+	config, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// the frames cannot be larger than the logical screen
+	if int64(config.Width)*int64(config.Height)*int64(countGIFFrames(data)) > limits.MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	animation, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(animation.Image) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	width, height := animation.Config.Width, animation.Config.Height
+	if width == 0 || height == 0 {
+		bounds := animation.Image[0].Bounds()
+		width, height = bounds.Max.X, bounds.Max.Y
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	var (
+		frames    []Frame
+		timestamp time.Duration
+		sample    = newSampler(sampleRate)
+	)
+	for i, paletted := range animation.Image {
+		var previous *image.RGBA
+		disposal := byte(0)
+		if i < len(animation.Disposal) {
+			disposal = animation.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+
+		draw.Draw(canvas, paletted.Bounds(), paletted, paletted.Bounds().Min, draw.Over)
+		if sample.keep(timestamp) {
+			if len(frames) >= limits.MaxFrames {
+				return nil, ErrTooManyFrames
+			}
+			frame := image.NewRGBA(canvas.Bounds())
+			draw.Draw(frame, frame.Bounds(), canvas, image.Point{}, draw.Src)
+			frames = append(frames, Frame{Index: i, Timestamp: timestamp, Image: frame})
+		}
+
+		// delays are expressed in hundredths of a second
+		if i < len(animation.Delay) {
+			timestamp += time.Duration(animation.Delay[i]) * 10 * time.Millisecond
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, paletted.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames, nil
+}
+
+// countGIFFrames walks the blocks of a gif, without decompressing them, and
+// returns the number of frames it holds. A truncated gif is counted up to the
+// truncation, gif.DecodeAll rejects it anyway.
+func countGIFFrames(data []byte) int {
+	// header and logical screen descriptor
+	i := 13
+	if len(data) < i {
+		return 0
+	}
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 0x07) + 1)
+	}
+
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21:
+			// extension: separator, label and sub-blocks
+			i += 2
+		case 0x2C:
+			// image descriptor, optional local color table and lzw code size
+			if i+10 > len(data) {
+				return frames
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << ((flags & 0x07) + 1)
+			}
+			i++
+			frames++
+		default:
+			// trailer or garbage
+			return frames
+		}
+		// skip the data sub-blocks up to the block terminator
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		i++
+	}
+	return frames
+}
+
+// SplitJPEGs finds the jpeg images stored back to back in data, which is how
+// motion jpeg streams are laid out. The markers are walked segment by segment
+// so that thumbnails embedded in the exif data do not end a frame early.
+func SplitJPEGs(data []byte) [][]byte {
+	var images [][]byte
+	for offset := 0; offset+1 < len(data); {
+		start := bytes.Index(data[offset:], []byte{0xFF, 0xD8})
+		if start < 0 {
+			break
+		}
+		start += offset
+		end := jpegEnd(data, start)
+		if end < 0 {
+			break
+		}
+		images = append(images, data[start:end])
+		offset = end
+	}
+	return images
+}
+
+// jpegEnd returns the offset right after the end of image marker of the jpeg starting at start, or -1
+func jpegEnd(data []byte, start int) int {
+	i := start + 2
+	for i+3 < len(data) {
+		if data[i] != 0xFF {
+			return -1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte
+			i++
+			continue
+		case marker == 0xD9:
+			return i + 2
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			// markers without a payload
+			i += 2
+			continue
+		}
+
+		length := int(data[i+2])<<8 | int(data[i+3])
+		i += 2 + length
+		if marker != 0xDA {
+			continue
+		}
+
+		// Entropy coded data follows the start of scan, it ends at the
+		// first marker which is neither a stuffed byte nor a restart
+		for i+1 < len(data) {
+			if data[i] == 0xFF && data[i+1] != 0x00 && (data[i+1] < 0xD0 || data[i+1] > 0xD7) {
+				break
+			}
+			i++
+		}
+	}
+	if i+1 < len(data) && data[i] == 0xFF && data[i+1] == 0xD9 {
+		return i + 2
+	}
+	return -1
+}
+
+// DecodeImages decodes the sampled frames of a sequence of encoded images
+// recorded at fps. A sampleRate of 0 keeps every frame.
+func DecodeImages(images [][]byte, fps float64, sampleRate float64, limits Limits) ([]Frame, error) {
+	if len(images) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	// the sample and its size are known from the headers, before decoding anything
+	var (
+		frames []Frame
+		pixels int64
+		sample = newSampler(sampleRate)
+	)
+	for i, encoded := range images {
+		timestamp := time.Duration(float64(i) / fps * float64(time.Second))
+		if !sample.keep(timestamp) {
+			continue
+		}
+		if len(frames) >= limits.MaxFrames {
+			return nil, ErrTooManyFrames
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(encoded))
+		if err != nil {
+			return nil, err
+		}
+		if pixels += int64(config.Width) * int64(config.Height); pixels > limits.MaxPixels {
+			return nil, ErrTooManyPixels
+		}
+		frames = append(frames, Frame{Index: i, Timestamp: timestamp})
+	}
+
+	for i := range frames {
+		img, _, err := image.Decode(bytes.NewReader(images[frames[i].Index]))
+		if err != nil {
+			return nil, err
+		}
+		frames[i].Image = img
+	}
+	return frames, nil
+}
+
+// DecodeMJPEG returns the sampled frames of a raw motion jpeg stream. Such
+// streams do not carry any timing, so the frames are spaced according to fps.
+func DecodeMJPEG(data []byte, fps float64, sampleRate float64, limits Limits) ([]Frame, error) {
+	return DecodeImages(SplitJPEGs(data), fps, sampleRate, limits)
+}
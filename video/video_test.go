@@ -0,0 +1,130 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+func encodeJPEG(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.Set(0, 0, c)
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatalf("error in encoding jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testLimits let every sequence of the tests through
+var testLimits = Limits{MaxFrames: 10, MaxPixels: 1 << 20}
+
+func TestDecodeMJPEG(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		stream = append(stream, encodeJPEG(t, color.Black)...)
+		// multipart boundaries between the frames must be ignored
+		stream = append(stream, []byte("\r\n--frame\r\n")...)
+	}
+	frames, err := DecodeMJPEG(stream, 10, 0, testLimits)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(frames) != 3 || frames[2].Timestamp.Milliseconds() != 200 {
+		t.Fatalf("unexpected frames: %d", len(frames))
+	}
+
+	// Keeping 5 frames per second of a 10 fps stream drops every other frame
+	frames, _ = DecodeMJPEG(stream, 10, 5, testLimits)
+	if len(frames) != 2 || frames[1].Index != 2 {
+		t.Fatalf("unexpected sampled frames: %d", len(frames))
+	}
+	if _, err := DecodeMJPEG(stream, 10, 0, Limits{MaxFrames: 2, MaxPixels: 1 << 20}); err != ErrTooManyFrames {
+		t.Fatalf("expected %v, got %v", ErrTooManyFrames, err)
+	}
+	// three 16x16 frames are more than 700 pixels
+	if _, err := DecodeMJPEG(stream, 10, 0, Limits{MaxFrames: 10, MaxPixels: 700}); err != ErrTooManyPixels {
+		t.Fatalf("expected %v, got %v", ErrTooManyPixels, err)
+	}
+}
+
+func TestDecodeGIF(t *testing.T) {
+	animation := &gif.GIF{}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 50)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, animation); err != nil {
+		t.Fatalf("error in encoding gif: %v", err)
+	}
+
+	frames, err := DecodeGIF(buf.Bytes(), 0, testLimits)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(frames) != 2 || frames[1].Timestamp.Milliseconds() != 500 {
+		t.Fatalf("unexpected frames: %+v", frames)
+	}
+
+	// every frame is counted, even those left out of the sample
+	if count := countGIFFrames(buf.Bytes()); count != 2 {
+		t.Fatalf("expected 2 frames, counted %d", count)
+	}
+	if _, err := DecodeGIF(buf.Bytes(), 0.1, Limits{MaxFrames: 10, MaxPixels: 100}); err != ErrTooManyPixels {
+		t.Fatalf("expected %v, got %v", ErrTooManyPixels, err)
+	}
+}
+
+func riffChunk(id string, payload []byte) []byte {
+	chunk := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestDecodeAVI(t *testing.T) {
+	header := make([]byte, 56)
+	binary.LittleEndian.PutUint32(header, 200000) // 5 fps
+	hdrl := append([]byte("hdrl"), riffChunk("avih", header)...)
+	movi := []byte("movi")
+	for i := 0; i < 4; i++ {
+		movi = append(movi, riffChunk("00dc", encodeJPEG(t, color.Black))...)
+	}
+	movi = append(movi, riffChunk("01wb", []byte("audio"))...)
+	body := append([]byte("AVI "), riffChunk("LIST", hdrl)...)
+	body = append(body, riffChunk("LIST", movi)...)
+	avi := riffChunk("RIFF", body)
+
+	frames, err := DecodeAVI(avi, 0, testLimits)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(frames) != 4 || frames[3].Timestamp.Milliseconds() != 600 {
+		t.Fatalf("unexpected frames: %+v", frames)
+	}
+	if _, err := DecodeAVI([]byte("not an avi file"), 0, testLimits); err != ErrInvalidAVI {
+		t.Fatalf("expected %v, got %v", ErrInvalidAVI, err)
+	}
+
+	// lists nested deeper than any real file are refused rather than walked
+	nested := riffChunk("LIST", append([]byte("movi"), riffChunk("00dc", encodeJPEG(t, color.Black))...))
+	for i := 0; i < 100; i++ {
+		nested = riffChunk("LIST", append([]byte("list"), nested...))
+	}
+	if _, err := DecodeAVI(riffChunk("RIFF", append([]byte("AVI "), nested...)), 0, testLimits); err != ErrInvalidAVI {
+		t.Fatalf("expected %v, got %v", ErrInvalidAVI, err)
+	}
+}
@@ -0,0 +1,231 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	fetch "github.com/rohith2506/facedetect/fetch"
+	redis "github.com/rohith2506/facedetect/redis"
+)
+
+// Headers sent along with every callback
+const (
+	SignatureHeader = "X-Facedetect-Signature"
+	TimestampHeader = "X-Facedetect-Timestamp"
+	JobHeader       = "X-Facedetect-Job"
+	AttemptHeader   = "X-Facedetect-Attempt"
+)
+
+const keyPrefix = "deliveries:"
+
+// ErrNoSecret is recorded instead of delivering a callback which cannot be signed
+var ErrNoSecret = errors.New("callbacks are disabled without a signing secret")
+
+// Delivery is a single attempt to deliver a callback
+type Delivery struct {
+	Attempt     int       `json:"attempt"`
+	URL         string    `json:"url"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Delivered   bool      `json:"delivered"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	Duration    int64     `json:"duration_ms"`
+}
+
+// Log records the delivery attempts of every job
+type Log interface {
+	Append(jobID string, delivery Delivery) error
+	List(jobID string) ([]Delivery, error)
+}
+
+// RedisLog keeps the delivery attempts in a redis list per job
+type RedisLog struct {
+	conn *redis.Connection
+	ttl  time.Duration
+}
+
+// NewRedisLog ...
+func NewRedisLog(conn *redis.Connection, ttl time.Duration) *RedisLog {
+	return &RedisLog{conn: conn, ttl: ttl}
+}
+
+// Append ...
+func (deliveryLog *RedisLog) Append(jobID string, delivery Delivery) error {
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return deliveryLog.conn.AppendToList(keyPrefix+jobID, value, deliveryLog.ttl)
+}
+
+// List ...
+func (deliveryLog *RedisLog) List(jobID string) ([]Delivery, error) {
+	values, err := deliveryLog.conn.GetList(keyPrefix + jobID)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(values))
+	for _, value := range values {
+		var delivery Delivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// MemoryLog keeps the delivery attempts in process
+type MemoryLog struct {
+	mutex      sync.RWMutex
+	deliveries map[string][]Delivery
+}
+
+// NewMemoryLog ...
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{deliveries: make(map[string][]Delivery)}
+}
+
+// Append ...
+func (deliveryLog *MemoryLog) Append(jobID string, delivery Delivery) error {
+	deliveryLog.mutex.Lock()
+	defer deliveryLog.mutex.Unlock()
+	deliveryLog.deliveries[jobID] = append(deliveryLog.deliveries[jobID], delivery)
+	return nil
+}
+
+// List ...
+func (deliveryLog *MemoryLog) List(jobID string) ([]Delivery, error) {
+	deliveryLog.mutex.RLock()
+	defer deliveryLog.mutex.RUnlock()
+	return append([]Delivery{}, deliveryLog.deliveries[jobID]...), nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "timestamp.body" using secret
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notifier posts the job results to the callback urls, retrying with an
+// exponential backoff until the receiver answers with a 2xx status. The
+// callbacks are always signed, without secret none is sent.
+type Notifier struct {
+	client      *http.Client
+	secret      []byte
+	log         Log
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewNotifier creates a notifier posting with client, which should refuse the internal
+// addresses since the callback urls are user supplied, see fetch.NewClient
+func NewNotifier(secret []byte, deliveryLog Log, client *http.Client, maxAttempts int, backoff time.Duration) *Notifier {
+	return &Notifier{
+		client:      client,
+		secret:      secret,
+		log:         deliveryLog,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+	}
+}
+
+// Enabled tells whether callbacks can be delivered, i.e. whether a secret is configured
+func (notifier *Notifier) Enabled() bool {
+	return len(notifier.secret) > 0
+}
+
+// Deliveries returns the delivery attempts made for the job
+func (notifier *Notifier) Deliveries(jobID string) ([]Delivery, error) {
+	return notifier.log.List(jobID)
+}
+
+// Notify delivers the payload to callbackURL. It blocks until the callback is
+// delivered or every attempt failed, so callers usually run it in a goroutine.
+func (notifier *Notifier) Notify(jobID string, callbackURL string, payload interface{}) bool {
+	if !notifier.Enabled() {
+		delivery := Delivery{Attempt: 1, URL: callbackURL, Error: ErrNoSecret.Error(), AttemptedAt: time.Now().UTC()}
+		if err := notifier.log.Append(jobID, delivery); err != nil {
+			log.Printf("Error in recording callback delivery of job %s: %v", jobID, err)
+		}
+		return false
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error in creating json marshal for callback of job %s: %v", jobID, err)
+		return false
+	}
+
+	backoff := notifier.backoff
+	for attempt := 1; attempt <= notifier.maxAttempts; attempt++ {
+		delivery, retry := notifier.deliver(jobID, callbackURL, body, attempt)
+		if err := notifier.log.Append(jobID, delivery); err != nil {
+			log.Printf("Error in recording callback delivery of job %s: %v", jobID, err)
+		}
+		if delivery.Delivered {
+			return true
+		}
+		if !retry || attempt == notifier.maxAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return false
+}
+
+// deliver makes a single attempt. The returned flag tells whether a failed attempt is worth retrying.
+func (notifier *Notifier) deliver(jobID string, callbackURL string, body []byte, attempt int) (Delivery, bool) {
+	start := time.Now()
+	delivery := Delivery{
+		Attempt:     attempt,
+		URL:         callbackURL,
+		AttemptedAt: start.UTC(),
+	}
+
+	request, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery, false
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(JobHeader, jobID)
+	request.Header.Set(AttemptHeader, strconv.Itoa(attempt))
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set(SignatureHeader, Sign(notifier.secret, timestamp, body))
+
+	response, err := notifier.client.Do(request)
+	delivery.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		// a forbidden address stays forbidden
+		return delivery, !errors.Is(err, fetch.ErrForbiddenAddress)
+	}
+	response.Body.Close()
+
+	delivery.StatusCode = response.StatusCode
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		delivery.Delivered = true
+		return delivery, false
+	}
+	delivery.Error = fmt.Sprintf("unexpected status %d", response.StatusCode)
+
+	// Client errors will not go away by themselves, except for rate limiting
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests ||
+		response.StatusCode == http.StatusRequestTimeout
+	return delivery, retry
+}
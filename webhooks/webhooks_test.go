@@ -0,0 +1,104 @@
+package webhooks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	fetch "github.com/rohith2506/facedetect/fetch"
+)
+
+// testClient reaches the local test servers only
+func testClient() *http.Client {
+	return fetch.NewClient(fetch.Config{Allow: fetch.MustParseCIDRs("127.0.0.0/8")})
+}
+
+func TestNotifySignsAndRetries(t *testing.T) {
+	secret := []byte("secret")
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		wanted := Sign(secret, r.Header.Get(TimestampHeader), body)
+		if r.Header.Get(SignatureHeader) != wanted {
+			t.Errorf("invalid signature %s", r.Header.Get(SignatureHeader))
+		}
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	deliveryLog := NewMemoryLog()
+	notifier := NewNotifier(secret, deliveryLog, testClient(), 5, time.Millisecond)
+	if !notifier.Notify("job", server.URL, map[string]string{"status": "done"}) {
+		t.Fatalf("callback was not delivered")
+	}
+
+	deliveries, _ := notifier.Deliveries("job")
+	if len(deliveries) != 3 || !deliveries[2].Delivered || deliveries[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected deliveries: %+v", deliveries)
+	}
+}
+
+func TestNotifyDoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier([]byte("secret"), NewMemoryLog(), testClient(), 5, time.Millisecond)
+	if notifier.Notify("job", server.URL, nil) || calls != 1 {
+		t.Fatalf("expected a single failed attempt, got %d", calls)
+	}
+}
+
+func TestNotifyRefusesInternalAddresses(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// the default deny list covers the loopback addresses
+	notifier := NewNotifier([]byte("secret"), NewMemoryLog(), fetch.NewClient(fetch.Config{}), 5, time.Millisecond)
+	if notifier.Notify("job", server.URL, nil) || calls != 0 {
+		t.Fatalf("expected the internal address to be refused, got %d calls", calls)
+	}
+	if deliveries, _ := notifier.Deliveries("job"); len(deliveries) != 1 {
+		t.Fatalf("expected a single attempt, got %+v", deliveries)
+	}
+
+	// redirects are not followed
+	notifier = NewNotifier([]byte("secret"), NewMemoryLog(), testClient(), 5, time.Millisecond)
+	if notifier.Notify("job", server.URL+"/redirect", nil) || calls != 1 {
+		t.Fatalf("expected the redirect not to be followed, got %d calls", calls)
+	}
+}
+
+func TestNotifyRequiresSecret(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(nil, NewMemoryLog(), testClient(), 5, time.Millisecond)
+	if notifier.Enabled() || notifier.Notify("job", server.URL, nil) || calls != 0 {
+		t.Fatalf("expected no unsigned callback, got %d calls", calls)
+	}
+	if deliveries, _ := notifier.Deliveries("job"); len(deliveries) != 1 || deliveries[0].Error != ErrNoSecret.Error() {
+		t.Fatalf("expected the refusal to be recorded, got %+v", deliveries)
+	}
+}